@@ -1,7 +1,6 @@
 package gopenid
 
 import (
-	"crypto/hmac"
 	"crypto/sha1"
 	"crypto/sha256"
 	"errors"
@@ -62,6 +61,15 @@ type AssocType struct {
 	secretSize int
 }
 
+// NewAssocType returns a new AssocType given name, hash function, and size of MAC key.
+func NewAssocType(name string, hashFunc func() hash.Hash, secretSize int) AssocType {
+	return AssocType{
+		name:       name,
+		hashFunc:   hashFunc,
+		secretSize: secretSize,
+	}
+}
+
 func (t *AssocType) Name() string {
 	return t.name
 }
@@ -74,17 +82,9 @@ func (t *AssocType) GetSecretSize() int {
 	return t.secretSize
 }
 
-func GetAssocTypeByName(name string) (assocType AssocType, err error) {
-	switch name {
-	case "HMAC-SHA1":
-		assocType = AssocHmacSha1
-	case "HMAC-SHA256":
-		assocType = AssocHmacSha256
-	default:
-		err = ErrUnknownAssocType
-	}
-
-	return
+// GetAssocTypeByName returns AssocType registered to DefaultRegistry as name.
+func GetAssocTypeByName(name string) (AssocType, error) {
+	return DefaultRegistry.GetAssocType(name)
 }
 
 type SessionType struct {
@@ -92,23 +92,39 @@ type SessionType struct {
 	assocTypes []AssocType
 }
 
+// NewSessionType returns a new SessionType given name, and association types it can transport.
+func NewSessionType(name string, assocTypes ...AssocType) SessionType {
+	return SessionType{
+		name:       name,
+		assocTypes: assocTypes,
+	}
+}
+
 func (t *SessionType) Name() string {
 	return t.name
 }
 
-func GetSessionTypeByName(name string) (sessionType SessionType, err error) {
-	switch name {
-	case "no-encryption":
-		sessionType = SessionNoEncryption
-	case "DH-SHA1":
-		sessionType = SessionDhSha1
-	case "DH-SHA256":
-		sessionType = SessionDhSha256
-	default:
-		err = ErrUnknownSessionType
+// GetAssocTypes returns association types t can transport.
+func (t *SessionType) GetAssocTypes() []AssocType {
+	ret := make([]AssocType, len(t.assocTypes))
+	copy(ret, t.assocTypes)
+	return ret
+}
+
+// Supports reports whether t can transport MAC key of assocType.
+func (t *SessionType) Supports(assocType AssocType) bool {
+	for _, supported := range t.assocTypes {
+		if supported.Name() == assocType.Name() {
+			return true
+		}
 	}
 
-	return
+	return false
+}
+
+// GetSessionTypeByName returns SessionType registered to DefaultRegistry as name.
+func GetSessionTypeByName(name string) (SessionType, error) {
+	return DefaultRegistry.GetSessionType(name)
 }
 
 type Association struct {
@@ -117,6 +133,7 @@ type Association struct {
 	secret      []byte
 	expires     time.Time
 	isStateless bool
+	macSigner   MACSigner
 }
 
 func NewAssociation(assocType AssocType, handle string, secret []byte, expires time.Time, isStateless bool) *Association {
@@ -153,7 +170,21 @@ func (assoc *Association) IsStateless() bool {
 	return assoc.isStateless
 }
 
-func (assoc *Association) Sign(msg Message, signed []string) (err error) {
+// SetMACSigner sets MACSigner used by Sign.
+// Association uses DefaultMACSigner unless it is set.
+func (assoc *Association) SetMACSigner(signer MACSigner) {
+	assoc.macSigner = signer
+}
+
+// GetMACSigner returns MACSigner used by Sign.
+func (assoc *Association) GetMACSigner() MACSigner {
+	if assoc.macSigner == nil {
+		return DefaultMACSigner
+	}
+	return assoc.macSigner
+}
+
+func (assoc *Association) Sign(msg *Message, signed []string) (err error) {
 	order := make([]string, len(signed))
 	for i, key := range signed {
 		order[i] = fmt.Sprintf("openid.%s", key)
@@ -169,9 +200,11 @@ func (assoc *Association) Sign(msg Message, signed []string) (err error) {
 		return
 	}
 
-	mac := hmac.New(assoc.assocType.hashFunc, assoc.secret)
-	mac.Write(kv)
-	sig := EncodeBase64(mac.Sum(nil))
+	mac, err := assoc.GetMACSigner().MAC(assoc, kv)
+	if err != nil {
+		return
+	}
+	sig := EncodeBase64(mac)
 
 	msg.AddArg(
 		NewMessageKey(msg.GetOpenIDNamespace(), "signed"),
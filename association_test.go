@@ -24,7 +24,7 @@ func TestAssociation(t *testing.T) {
 
 	var NsExt NamespaceURI = "http://example.com/"
 
-	msg := Message{
+	msg := &Message{
 		namespace: NsOpenID20,
 		nsuri2nsalias: map[NamespaceURI]string{
 			NsExt: "example",
@@ -0,0 +1,172 @@
+// Package discovery finds OpenID endpoints of identifiers by the Yadis protocol,
+// falling back to HTML-based discovery.
+//
+// Discoverer talks HTTP only through the http.Client it is given, so that tests can run
+// against httptest servers and applications can control timeouts, proxies and redirects.
+package discovery
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+const (
+	DefaultMaxBodySize = 1 << 20 // 1 MiB
+
+	xrdsContentType = "application/xrds+xml"
+	acceptHeader    = "application/xrds+xml, text/html;q=0.5, application/xhtml+xml;q=0.5"
+)
+
+var (
+	ErrNoEndpoint          = errors.New("no OpenID endpoint found")
+	ErrUnexpectedStatus    = errors.New("unexpected HTTP status")
+	ErrBodyTooLarge        = errors.New("response body too large")
+	ErrNotXRDS             = errors.New("X-XRDS-Location does not serve XRDS document")
+	ErrInvalidXRDSLocation = errors.New("invalid X-XRDS-Location")
+)
+
+// Result is a result of discovery.
+type Result struct {
+	// URL is the URL the identifier resolved to after following redirects.
+	// It is the claimed identifier if the identifier is a URL.
+	URL string
+	// XRDS is the XRDS document of the identifier, if found by Yadis.
+	XRDS *gopenid.XRDSDocument
+	// Endpoints are OpenID endpoints in the order relying parties should try them.
+	Endpoints []gopenid.OpenIDEndpoint
+}
+
+// Discoverer performs discovery on identifiers.
+type Discoverer struct {
+	client *http.Client
+
+	// MaxBodySize is the maximum size of response bodies read.
+	MaxBodySize int64
+}
+
+// New returns a Discoverer sending HTTP requests by client.
+// http.DefaultClient is used if client is nil.
+func New(client *http.Client) *Discoverer {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Discoverer{
+		client:      client,
+		MaxBodySize: DefaultMaxBodySize,
+	}
+}
+
+// Discover performs Yadis discovery on the URL identifier, and HTML-based discovery
+// if Yadis found no XRDS document or no OpenID service in it, including when
+// X-XRDS-Location cannot be fetched.
+// identifier must already be normalized.
+func (d *Discoverer) Discover(identifier string) (result *Result, err error) {
+	res, body, err := d.get(identifier)
+	if err != nil {
+		return
+	}
+
+	result = &Result{
+		URL: res.Request.URL.String(),
+	}
+
+	var (
+		xrdsLocation = res.Header.Get("X-XRDS-Location")
+		head         *htmlHead
+	)
+	if isXRDS(res) {
+		result.XRDS, err = gopenid.DecodeXRDS(bytes.NewReader(body))
+	} else {
+		head = scanHTMLHead(body)
+		if xrdsLocation == "" {
+			xrdsLocation = head.xrdsLocation
+		}
+
+		if xrdsLocation != "" {
+			result.XRDS, err = d.fetchXRDS(res.Request.URL, xrdsLocation)
+			if err != nil && len(head.endpoints()) > 0 {
+				// fall back to HTML-based discovery
+				result.XRDS, err = nil, nil
+			}
+		}
+	}
+	if err != nil {
+		result = nil
+		return
+	}
+
+	if result.XRDS != nil {
+		result.Endpoints, err = result.XRDS.GetOpenIDEndpoints()
+	}
+	if err == nil && len(result.Endpoints) == 0 && head != nil {
+		// the XRDS document may describe services other than OpenID
+		result.Endpoints = head.endpoints()
+	}
+	if err == nil && len(result.Endpoints) == 0 {
+		err = ErrNoEndpoint
+	}
+	if err != nil {
+		result = nil
+	}
+
+	return
+}
+
+func (d *Discoverer) fetchXRDS(base *url.URL, xrdsLocation string) (et *gopenid.XRDSDocument, err error) {
+	location, err := base.Parse(xrdsLocation)
+	if err != nil || !(location.Scheme == "http" || location.Scheme == "https") {
+		err = ErrInvalidXRDSLocation
+		return
+	}
+
+	res, body, err := d.get(location.String())
+	if err != nil {
+		return
+	} else if !isXRDS(res) {
+		err = ErrNotXRDS
+		return
+	}
+
+	return gopenid.DecodeXRDS(bytes.NewReader(body))
+}
+
+func (d *Discoverer) get(rawurl string) (res *http.Response, body []byte, err error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", acceptHeader)
+
+	res, err = d.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = ErrUnexpectedStatus
+		return
+	}
+
+	body, err = ioutil.ReadAll(io.LimitReader(res.Body, d.MaxBodySize+1))
+	if err != nil {
+		return
+	} else if int64(len(body)) > d.MaxBodySize {
+		err = ErrBodyTooLarge
+	}
+
+	return
+}
+
+func isXRDS(res *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return err == nil && mediaType == xrdsContentType
+}
@@ -0,0 +1,195 @@
+package discovery
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+const testXRDS = `<?xml version="1.0" encoding="UTF-8"?>
+<xrds:XRDS xmlns:xrds="xri://$xrds" xmlns="xri://$xrd*($v*2.0)">
+  <XRD>
+    <Service priority="0">
+      <Type>http://specs.openid.net/auth/2.0/signon</Type>
+      <URI>http://op.example.com/openid</URI>
+      <LocalID>http://example.com/alice</LocalID>
+    </Service>
+  </XRD>
+</xrds:XRDS>`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	writeXRDS := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/xrds+xml; charset=utf-8")
+		fmt.Fprint(w, testXRDS)
+	}
+
+	mux.HandleFunc("/xrds", func(w http.ResponseWriter, r *http.Request) {
+		writeXRDS(w)
+	})
+	mux.HandleFunc("/negotiated", func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "application/xrds+xml") {
+			writeXRDS(w)
+			return
+		}
+		fmt.Fprint(w, "<html></html>")
+	})
+	mux.HandleFunc("/header", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-XRDS-Location", server.URL+"/xrds")
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html></html>")
+	})
+	mux.HandleFunc("/meta", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<!DOCTYPE html><html><head>
+<!-- <meta http-equiv="X-XRDS-Location" content="/commented"> -->
+<script>var s = '<meta http-equiv="X-XRDS-Location" content="/scripted">';</script>
+<META HTTP-EQUIV="x-xrds-location" CONTENT='/xrds'>
+</head></html>`)
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head>
+<title>alice</title>
+<link rel="openid2.provider openid.server" href="http://op.example.com/openid?a=b&amp;c=d">
+<link rel=openid2.local_id href=http://example.com/alice>
+<link rel="openid.delegate" href="http://example.com/alice-v1"/>
+</head><body>
+<link rel="openid2.provider" href="http://evil.example.com/">
+</body></html>`)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/html", http.StatusFound)
+	})
+	mux.HandleFunc("/not-xrds", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-XRDS-Location", "/html")
+		fmt.Fprint(w, "<html></html>")
+	})
+	mux.HandleFunc("/broken-xrds", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-XRDS-Location", "/unknown")
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><link rel="openid2.provider" href="http://op.example.com/openid"></head></html>`)
+	})
+	mux.HandleFunc("/other-xrds", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xrds+xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<xrds:XRDS xmlns:xrds="xri://$xrds" xmlns="xri://$xrd*($v*2.0)">
+  <XRD>
+    <Service>
+      <Type>http://example.com/other</Type>
+      <URI>http://example.com/other</URI>
+    </Service>
+  </XRD>
+</xrds:XRDS>`)
+	})
+	mux.HandleFunc("/other-services", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-XRDS-Location", "/other-xrds")
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><link rel="openid2.provider" href="http://op.example.com/openid"></head></html>`)
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("a", 2048))
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><head></head></html>")
+	})
+
+	return server
+}
+
+func TestDiscover(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	d := New(server.Client())
+
+	yadis := []gopenid.OpenIDEndpoint{
+		{Type: gopenid.NsOpenID20Signon, URI: "http://op.example.com/openid", LocalID: "http://example.com/alice"},
+	}
+
+	for _, path := range []string{"/xrds", "/negotiated", "/header", "/meta"} {
+		result, err := d.Discover(server.URL + path)
+		if assert.Nil(t, err, path) {
+			assert.Equal(t, result.URL, server.URL+path)
+			assert.NotNil(t, result.XRDS, path)
+			assert.Equal(t, result.Endpoints, yadis, path)
+		}
+	}
+
+	result, err := d.Discover(server.URL + "/redirect")
+	if assert.Nil(t, err) {
+		assert.Equal(t, result.URL, server.URL+"/html")
+		assert.Nil(t, result.XRDS)
+		assert.Equal(t, result.Endpoints, []gopenid.OpenIDEndpoint{
+			{Type: gopenid.NsOpenID20Signon, URI: "http://op.example.com/openid?a=b&c=d", LocalID: "http://example.com/alice"},
+			{Type: gopenid.NsOpenID11, URI: "http://op.example.com/openid?a=b&c=d", LocalID: "http://example.com/alice-v1"},
+		})
+	}
+
+	// HTML links are used if X-XRDS-Location cannot be fetched
+	result, err = d.Discover(server.URL + "/broken-xrds")
+	if assert.Nil(t, err) {
+		assert.Nil(t, result.XRDS)
+		assert.Equal(t, result.Endpoints, []gopenid.OpenIDEndpoint{
+			{Type: gopenid.NsOpenID20Signon, URI: "http://op.example.com/openid"},
+		})
+	}
+
+	// HTML links are used if the XRDS document has no OpenID service
+	result, err = d.Discover(server.URL + "/other-services")
+	if assert.Nil(t, err) {
+		assert.NotNil(t, result.XRDS)
+		assert.Equal(t, result.Endpoints, []gopenid.OpenIDEndpoint{
+			{Type: gopenid.NsOpenID20Signon, URI: "http://op.example.com/openid"},
+		})
+	}
+	_, err = d.Discover(server.URL + "/other-xrds")
+	assert.Equal(t, err, ErrNoEndpoint)
+
+	_, err = d.Discover(server.URL + "/not-xrds")
+	assert.Equal(t, err, ErrNotXRDS)
+	_, err = d.Discover(server.URL + "/empty")
+	assert.Equal(t, err, ErrNoEndpoint)
+	_, err = d.Discover(server.URL + "/unknown")
+	assert.Equal(t, err, ErrUnexpectedStatus)
+
+	d.MaxBodySize = 1024
+	_, err = d.Discover(server.URL + "/large")
+	assert.Equal(t, err, ErrBodyTooLarge)
+}
+
+func TestScanHTMLHead(t *testing.T) {
+	head := scanHTMLHead([]byte(`<html><head><link rel="stylesheet" href="/style.css"><link rel="OpenID.Server" href="http://op.example.com/"`))
+	assert.Equal(t, head.server, "http://op.example.com/")
+	assert.Equal(t, head.provider, "")
+
+	head = scanHTMLHead([]byte(`<html><head><style>a { content: "<link rel='openid.server' href='x'>" }</style></head>`))
+	assert.Equal(t, head.server, "")
+
+	head = scanHTMLHead([]byte(`<link rel="openid.server" href="http://first.example.com/"><link rel="openid.server" href="http://second.example.com/">`))
+	assert.Equal(t, head.server, "http://first.example.com/")
+
+	// raw text is skipped by the original bytes, whatever it contains
+	link := `<link rel="openid.server" href="http://op.example.com/">`
+	for _, text := range []string{
+		strings.Repeat("\xff", 300),
+		strings.Repeat("\u023a", 300),
+		"\u023a\xff<link rel='openid.server' href='x'>",
+		"</scripty><link rel='openid.server' href='x'>",
+	} {
+		head = scanHTMLHead([]byte("<html><head><script>" + text + "</SCRIPT>" + link))
+		assert.Equal(t, head.server, "http://op.example.com/", "%q", text)
+		head = scanHTMLHead([]byte("<html><head><style>" + text + "</style >" + link))
+		assert.Equal(t, head.server, "http://op.example.com/", "%q", text)
+	}
+
+	head = scanHTMLHead([]byte("<html><head><script>" + strings.Repeat("\xff", 300)))
+	assert.Equal(t, head.server, "")
+}
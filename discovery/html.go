@@ -0,0 +1,227 @@
+package discovery
+
+import (
+	"bytes"
+	"html"
+	"strings"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+// htmlHead is what discovery needs from the head element of an HTML document.
+type htmlHead struct {
+	xrdsLocation string
+
+	provider string // openid2.provider
+	localID  string // openid2.local_id
+	server   string // openid.server
+	delegate string // openid.delegate
+}
+
+func (head *htmlHead) endpoints() (endpoints []gopenid.OpenIDEndpoint) {
+	if head.provider != "" {
+		endpoints = append(endpoints, gopenid.OpenIDEndpoint{
+			Type:    gopenid.NsOpenID20Signon,
+			URI:     head.provider,
+			LocalID: head.localID,
+		})
+	}
+
+	if head.server != "" {
+		endpoints = append(endpoints, gopenid.OpenIDEndpoint{
+			Type:    gopenid.NsOpenID11,
+			URI:     head.server,
+			LocalID: head.delegate,
+		})
+	}
+
+	return
+}
+
+// scanHTMLHead scans link and meta elements in the head of the HTML document b.
+//
+// It is not a full HTML parser. It skips comments, and the contents of script, style and
+// similar elements, and stops at the end of the head or at the beginning of the body.
+func scanHTMLHead(b []byte) *htmlHead {
+	head := new(htmlHead)
+
+	for len(b) > 0 {
+		idx := bytes.IndexByte(b, '<')
+		if idx < 0 {
+			break
+		}
+		b = b[idx+1:]
+
+		if bytes.HasPrefix(b, []byte("!--")) {
+			if end := bytes.Index(b, []byte("-->")); end > -1 {
+				b = b[end+3:]
+				continue
+			}
+			break
+		}
+
+		var (
+			name  string
+			attrs map[string]string
+		)
+		name, attrs, b = scanTag(b)
+
+		switch name {
+		case "/head", "body":
+			return head
+		case "script", "style", "title", "textarea", "noscript":
+			// skip raw text, which may contain anything looking like tags
+			if end := indexEndTag(b, name); end > -1 {
+				b = b[end:]
+				continue
+			}
+			return head
+		case "meta":
+			if strings.EqualFold(attrs["http-equiv"], "X-XRDS-Location") && head.xrdsLocation == "" {
+				head.xrdsLocation = attrs["content"]
+			}
+		case "link":
+			for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+				head.setLink(rel, attrs["href"])
+			}
+		}
+	}
+
+	return head
+}
+
+// indexEndTag returns the index of the end tag of the element name in b, or -1.
+// Only ASCII letters are folded, so the index is always an index of b whatever b contains.
+func indexEndTag(b []byte, name string) int {
+	for offset := 0; ; {
+		idx := bytes.Index(b[offset:], []byte("</"))
+		if idx < 0 {
+			return -1
+		}
+		idx += offset
+
+		rest := b[idx+2:]
+		if len(rest) >= len(name) && equalFoldASCII(rest[:len(name)], name) &&
+			(len(rest) == len(name) || isHTMLSpace(rest[len(name)]) || rest[len(name)] == '/' || rest[len(name)] == '>') {
+			return idx
+		}
+		offset = idx + 2
+	}
+}
+
+// equalFoldASCII reports whether b equals the lowercase ASCII string s, ignoring the case of ASCII letters.
+func equalFoldASCII(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+
+	for i := range b {
+		c := b[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (head *htmlHead) setLink(rel, href string) {
+	var dst *string
+	switch rel {
+	case "openid2.provider":
+		dst = &head.provider
+	case "openid2.local_id":
+		dst = &head.localID
+	case "openid.server":
+		dst = &head.server
+	case "openid.delegate":
+		dst = &head.delegate
+	default:
+		return
+	}
+
+	// the first one wins
+	if *dst == "" {
+		*dst = href
+	}
+}
+
+// scanTag scans a tag just after '<', and returns its lowercased name, attributes, and the rest of b.
+func scanTag(b []byte) (name string, attrs map[string]string, rest []byte) {
+	i := 0
+	for i < len(b) && !isHTMLSpace(b[i]) && b[i] != '>' && !(b[i] == '/' && i > 0) {
+		i++
+	}
+	name = strings.ToLower(string(b[:i]))
+	attrs = make(map[string]string)
+
+	for i < len(b) {
+		for i < len(b) && (isHTMLSpace(b[i]) || b[i] == '/') {
+			i++
+		}
+		if i >= len(b) {
+			break
+		} else if b[i] == '>' {
+			i++
+			break
+		}
+
+		start := i
+		for i < len(b) && !isHTMLSpace(b[i]) && b[i] != '=' && b[i] != '>' && b[i] != '/' {
+			i++
+		}
+		key := strings.ToLower(string(b[start:i]))
+
+		for i < len(b) && isHTMLSpace(b[i]) {
+			i++
+		}
+		if i >= len(b) || b[i] != '=' {
+			if _, ok := attrs[key]; !ok {
+				attrs[key] = ""
+			}
+			continue
+		}
+		i++
+		for i < len(b) && isHTMLSpace(b[i]) {
+			i++
+		}
+
+		var value []byte
+		if i < len(b) && (b[i] == '"' || b[i] == '\'') {
+			quote := b[i]
+			i++
+			start = i
+			for i < len(b) && b[i] != quote {
+				i++
+			}
+			value = b[start:i]
+			if i < len(b) {
+				i++
+			}
+		} else {
+			start = i
+			for i < len(b) && !isHTMLSpace(b[i]) && b[i] != '>' {
+				i++
+			}
+			value = b[start:i]
+		}
+
+		// duplicated attributes are ignored as browsers do
+		if _, ok := attrs[key]; !ok {
+			attrs[key] = html.UnescapeString(string(value))
+		}
+	}
+
+	rest = b[i:]
+	return
+}
+
+func isHTMLSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\f':
+		return true
+	}
+	return false
+}
@@ -13,7 +13,6 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -123,94 +122,32 @@ func (s *FileStore) StoreNonce(nonce string) {
 	return
 }
 
-type OpenIDProvider struct {
-	p *provider.Provider
-}
-
-func (p *OpenIDProvider) respond(w http.ResponseWriter, r *http.Request, res provider.Response) {
-	if res.NeedsRedirect() {
-		status := http.StatusFound
-		if res.IsPermanently() {
-			status = http.StatusMovedPermanently
-		}
-		http.Redirect(w, r, res.GetRedirectTo(), status)
-	} else {
-		w.Header().Set("Content-Type", res.GetContentType())
-		w.Write(res.GetBody())
-	}
-}
-
-func (p *OpenIDProvider) handleRequest(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
-	if err != nil {
-		http.Error(w, err.Error(), 400)
-		return
-	}
-
-	msg, err := gopenid.MessageFromQuery(r.Form)
-	if err != nil {
-		http.Error(w, err.Error(), 400)
-		return
-	}
-
-	session, err := p.p.EstablishSession(r.Method, msg)
-	if err != nil {
-		http.Error(w, err.Error(), 400)
-		return
-	}
-
-	switch ret := session.(type) {
-	case *provider.CheckIDSession:
-		ret.Accept(
-			"http://yosida95-ubuntu1:6543/users/yosida95",
-			"http://yosida95-ubuntu1:6543/users/yosida95",
-		)
-	}
-
-	res, err := session.GetResponse()
+func main() {
+	op, err := provider.New(
+		fmt.Sprintf("%s/openid", URI_PREFIX),
+		&FileStore{
+			prefix: STORE_PREFIX,
+		},
+		provider.WithAssociationLifetime(AssociationLifetime),
+		provider.WithSecretGenerator(rand.Reader),
+		provider.WithLogger(log.New(os.Stderr, "openid: ", log.LstdFlags)),
+		provider.WithAuthenticator(provider.AuthenticatorFunc(func(req *provider.AuthRequest) (provider.AuthResult, error) {
+			identity := fmt.Sprintf("%s/users/yosida95", URI_PREFIX)
+			return provider.AcceptResult(identity, identity), nil
+		})),
+	)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+		log.Fatal("NewProvider: ", err)
 	}
 
-	p.respond(w, r, res)
-}
-
-func (p *OpenIDProvider) serveProviderXRDS(w http.ResponseWriter, r *http.Request) {
-	p.respond(w, r, p.p.GetYadisProviderIdentifier())
-}
-
-func (p *OpenIDProvider) serveClaimedXRDS(w http.ResponseWriter, r *http.Request) {
-	p.respond(
-		w, r,
-		p.p.GetYadisClaimedIdentifier(fmt.Sprintf("%s/users/%s",
-			URI_PREFIX,
-			strings.SplitN(r.URL.Path, "/", 3)[2],
-		)),
-	)
-}
-
-func main() {
-	p := OpenIDProvider{
-		p: provider.NewProvider(
-			fmt.Sprintf("%s/openid", URI_PREFIX),
-			&FileStore{
-				prefix: STORE_PREFIX,
-			},
-			AssociationLifetime,
-			rand.Reader,
-		),
-	}
+	h := provider.NewHandler(op)
+	h.XRDSPath = "/xrds"
+	h.ProviderIdentifierPath = "/"
+	h.IdentityPrefix = "/users/"
 
-	http.HandleFunc("/openid", p.handleRequest)
-	http.HandleFunc("/xrds", p.serveProviderXRDS)
-	http.HandleFunc("/users/", p.serveClaimedXRDS)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("X-XRDS-Location", fmt.Sprintf("%s/xrds", URI_PREFIX))
-		w.Write([]byte("OpenID 2.0 Sample Provider"))
-	})
+	http.Handle("/", h)
 
-	err := http.ListenAndServe(":6543", nil)
+	err = http.ListenAndServe(":6543", nil)
 	if err != nil {
 		log.Fatal("ListenAndServe: ", err)
 	}
@@ -0,0 +1,230 @@
+package gopenid
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrMalformedIdentifier = errors.New("malformed identifier")
+)
+
+// NormalizeIdentifier normalizes the User-Supplied Identifier or the Claimed Identifier
+// identifier as defined in section 7.2 of OpenID Authentication 2.0.
+//
+// XRIs are returned without the xri:// prefix and isXRI set. Other identifiers are taken as
+// URLs: http:// is added unless the scheme is http or https, the fragment is removed, and
+// the URL is normalized as defined in section 6 of RFC 3986, including the host converted
+// by NormalizeHost.
+func NormalizeIdentifier(identifier string) (normalized string, isXRI bool, err error) {
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		err = ErrMalformedIdentifier
+		return
+	}
+
+	if len(identifier) >= 6 && strings.EqualFold(identifier[:6], "xri://") {
+		if identifier = identifier[6:]; identifier == "" {
+			err = ErrMalformedIdentifier
+			return
+		}
+		isXRI = true
+	}
+	switch identifier[0] {
+	case '=', '@', '+', '$', '!', '(':
+		isXRI = true
+	}
+	if isXRI {
+		normalized = identifier
+		return
+	}
+
+	normalized, err = normalizeURL(identifier)
+	return
+}
+
+func normalizeURL(rawurl string) (normalized string, err error) {
+	if idx := strings.IndexByte(rawurl, '#'); idx > -1 {
+		rawurl = rawurl[:idx]
+	}
+
+	var scheme string
+	if idx := strings.Index(rawurl, "://"); idx > -1 {
+		scheme = strings.ToLower(rawurl[:idx])
+	}
+	switch scheme {
+	case "http", "https":
+		rawurl = rawurl[len(scheme)+3:]
+	case "":
+		scheme = "http"
+	default:
+		if !strings.ContainsAny(scheme, "/?.") {
+			// other schemes cannot be identifiers
+			err = ErrMalformedIdentifier
+			return
+		}
+		scheme = "http"
+	}
+
+	// authority ends at the first of / ? or the end
+	authority := rawurl
+	rest := ""
+	if idx := strings.IndexAny(rawurl, "/?"); idx > -1 {
+		authority, rest = rawurl[:idx], rawurl[idx:]
+	}
+
+	var userinfo string
+	if idx := strings.LastIndex(authority, "@"); idx > -1 {
+		userinfo, authority = authority[:idx+1], authority[idx+1:]
+		if userinfo, err = normalizePercentEncoding(userinfo); err != nil {
+			return
+		}
+	}
+
+	host, port := authority, ""
+	if idx := strings.LastIndex(authority, ":"); idx > -1 && idx > strings.LastIndex(authority, "]") {
+		host, port = authority[:idx], authority[idx+1:]
+		for i := 0; i < len(port); i++ {
+			if port[i] < '0' || port[i] > '9' {
+				err = ErrMalformedIdentifier
+				return
+			}
+		}
+	}
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	if host, err = percentDecode(host); err != nil {
+		return
+	} else if host, err = NormalizeHost(host); err != nil || strings.ContainsAny(host, " \t\r\n%/?#@") {
+		err = ErrMalformedIdentifier
+		return
+	}
+
+	path, query := rest, ""
+	if idx := strings.IndexByte(rest, '?'); idx > -1 {
+		path, query = rest[:idx], rest[idx:]
+	}
+	if path, err = normalizePercentEncoding(path); err != nil {
+		return
+	} else if query, err = normalizePercentEncoding(query); err != nil {
+		return
+	}
+	path = removeDotSegments(path)
+	if path == "" {
+		path = "/"
+	}
+
+	normalized = scheme + "://" + userinfo + host
+	if port != "" {
+		normalized += ":" + port
+	}
+	normalized += path + query
+
+	return
+}
+
+// percentDecode decodes all percent-encoded octets in s.
+func percentDecode(s string) (string, error) {
+	if strings.IndexByte(s, '%') < 0 {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return "", ErrMalformedIdentifier
+		}
+		b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+		i += 2
+	}
+
+	return b.String(), nil
+}
+
+// normalizePercentEncoding uppercases hexadecimal digits of percent-encoded octets,
+// decodes those of unreserved characters, and percent-encodes octets which may not appear in URLs.
+func normalizePercentEncoding(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return "", ErrMalformedIdentifier
+			}
+
+			decoded := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(decoded) {
+				b.WriteByte(decoded)
+			} else {
+				b.WriteByte('%')
+				b.WriteByte(upperHex[decoded>>4])
+				b.WriteByte(upperHex[decoded&0x0f])
+			}
+			i += 2
+		case c <= ' ' || c >= 0x7f || strings.IndexByte(`"<>\^`+"`{|}", c) > -1:
+			b.WriteByte('%')
+			b.WriteByte(upperHex[c>>4])
+			b.WriteByte(upperHex[c&0x0f])
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String(), nil
+}
+
+// removeDotSegments removes "." and ".." segments from path as defined in section 5.2.4 of RFC 3986.
+func removeDotSegments(path string) string {
+	var output []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+			if last {
+				output = append(output, "")
+			}
+		case "..":
+			if len(output) > 1 {
+				output = output[:len(output)-1]
+			}
+			if last {
+				output = append(output, "")
+			}
+		default:
+			output = append(output, segment)
+		}
+	}
+
+	return strings.Join(output, "/")
+}
+
+const upperHex = "0123456789ABCDEF"
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func isUnreserved(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,72 @@
+package gopenid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeIdentifier(t *testing.T) {
+	for _, testCase := range []struct {
+		identifier string
+		normalized string
+		isXRI      bool
+		err        error
+	}{
+		{"example.com", "http://example.com/", false, nil},
+		{"  example.com/alice  ", "http://example.com/alice", false, nil},
+		{"HTTP://Example.COM:80/alice#fragment", "http://example.com/alice", false, nil},
+		{"https://example.com:443", "https://example.com/", false, nil},
+		{"https://example.com:80/", "https://example.com:80/", false, nil},
+		{"http://example.com:8080?query", "http://example.com:8080/?query", false, nil},
+		{"http://example.com/a/./b/../c/%7ealice/%2f%e3%81%82", "http://example.com/a/c/~alice/%2F%E3%81%82", false, nil},
+		{"http://example.com/../../a/..", "http://example.com/", false, nil},
+		{"http://example.com/a b/ä", "http://example.com/a%20b/%C3%A4", false, nil},
+		{"http://user@Example.com/", "http://user@example.com/", false, nil},
+		{"http://[2001:DB8::1]:8080/", "http://[2001:db8::1]:8080/", false, nil},
+		{"http://Bücher.example/", "http://xn--bcher-kva.example/", false, nil},
+		{"http://%E4%BE%8B%E3%81%88.%E3%83%86%E3%82%B9%E3%83%88/", "http://xn--r8jz45g.xn--zckzah/", false, nil},
+		{"ftp://example.com/", "", false, ErrMalformedIdentifier},
+		{"=example", "=example", true, nil},
+		{"@example*alice", "@example*alice", true, nil},
+		{"xri://=example", "=example", true, nil},
+		{"XRI://$dns*example.com", "$dns*example.com", true, nil},
+		{"", "", false, ErrMalformedIdentifier},
+		{"xri://", "", false, ErrMalformedIdentifier},
+		{"http://", "", false, ErrMalformedIdentifier},
+		{"http://example.com:http/", "", false, ErrMalformedIdentifier},
+		{"http://example.com/%zz", "", false, ErrMalformedIdentifier},
+		{"http://exa%2Fmple.com/", "", false, ErrMalformedIdentifier},
+	} {
+		normalized, isXRI, err := NormalizeIdentifier(testCase.identifier)
+		assert.Equal(t, err, testCase.err, testCase.identifier)
+		assert.Equal(t, normalized, testCase.normalized, testCase.identifier)
+		assert.Equal(t, isXRI, testCase.isXRI, testCase.identifier)
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	for _, testCase := range []struct {
+		host       string
+		normalized string
+		err        error
+	}{
+		{"Example.COM", "example.com", nil},
+		{"example.com.", "example.com.", nil},
+		{"bücher.example", "xn--bcher-kva.example", nil},
+		{"MÜNCHEN.de", "xn--mnchen-3ya.de", nil},
+		{"例え。テスト", "xn--r8jz45g.xn--zckzah", nil},
+		{"ليهمابتكلموشعربي؟", "xn--egbpdaj6bu4bxfgehfvwxn", nil},
+		{"ＥＸＡＭＰＬＥ.com", "example.com", nil},
+		{"ｂüｃｈｅｒ－１.example", "xn--bcher-1-n2a.example", nil},
+		{"[::1]", "[::1]", nil},
+		{"", "", ErrMalformedHost},
+		{"a..b", "", ErrMalformedHost},
+		{"[::1", "", ErrMalformedHost},
+		{"example．com／evil", "", ErrMalformedHost},
+	} {
+		normalized, err := NormalizeHost(testCase.host)
+		assert.Equal(t, err, testCase.err, testCase.host)
+		assert.Equal(t, normalized, testCase.normalized, testCase.host)
+	}
+}
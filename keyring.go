@@ -0,0 +1,379 @@
+package gopenid
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	KeyringKeySize      = 32  // AES-256
+	MaxKeyringKeyIDSize = 255 // sealed data stores the length of key IDs in a byte
+)
+
+var (
+	ErrKeyNotFound      = errors.New("key not found")
+	ErrNoActiveKey      = errors.New("no active key")
+	ErrKeyRetired       = errors.New("key has been retired")
+	ErrInvalidKeySize   = errors.New("invalid key size")
+	ErrInvalidKeyID     = errors.New("invalid key id")
+	ErrMalformedSealed  = errors.New("malformed sealed data")
+	ErrMalformedKeyring = errors.New("malformed keyring")
+)
+
+// KeyringKey is a master key held by Keyring.
+type KeyringKey struct {
+	ID      string
+	Secret  []byte
+	Created time.Time
+	Retired time.Time // zero while the key is active or has never been used
+}
+
+// IsRetired reports whether k has been retired.
+func (k *KeyringKey) IsRetired() bool {
+	return !k.Retired.IsZero()
+}
+
+// IsUsable reports whether k can still open data at now, given the grace period of retired keys.
+func (k *KeyringKey) IsUsable(now time.Time, gracePeriod time.Duration) bool {
+	return !k.IsRetired() || now.Before(k.Retired.Add(gracePeriod))
+}
+
+// Keyring is a set of master keys protecting secrets at rest.
+//
+// New data is always sealed with the active key. Retired keys stay usable for
+// opening data until their grace period elapses, so that rotating the active key
+// does not invalidate everything sealed before the rotation.
+type Keyring struct {
+	mu          sync.RWMutex
+	gracePeriod time.Duration
+	active      string
+	keys        map[string]*KeyringKey
+}
+
+// NewKeyring returns an empty Keyring whose retired keys are kept for gracePeriod.
+func NewKeyring(gracePeriod time.Duration) *Keyring {
+	return &Keyring{
+		gracePeriod: gracePeriod,
+		keys:        make(map[string]*KeyringKey),
+	}
+}
+
+// GetGracePeriod returns how long retired keys remain usable.
+func (kr *Keyring) GetGracePeriod() time.Duration {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.gracePeriod
+}
+
+// AddKey registers k to kr without activating it.
+// The ID of k must be 1 to MaxKeyringKeyIDSize bytes long.
+func (kr *Keyring) AddKey(k *KeyringKey) error {
+	if len(k.Secret) != KeyringKeySize {
+		return ErrInvalidKeySize
+	} else if !isValidKeyringKeyID(k.ID) {
+		return ErrInvalidKeyID
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	copied := *k
+	kr.keys[k.ID] = &copied
+	return nil
+}
+
+// SetActive makes the key identified by id the active key.
+// Previously active key is retired at now.
+func (kr *Keyring) SetActive(id string, now time.Time) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	return kr.setActive(id, now)
+}
+
+func (kr *Keyring) setActive(id string, now time.Time) error {
+	k, ok := kr.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	} else if k.IsRetired() {
+		return ErrKeyRetired
+	}
+
+	if prev, ok := kr.keys[kr.active]; ok && prev.ID != id {
+		prev.Retired = now
+	}
+	kr.active = id
+
+	return nil
+}
+
+// Rotate generates a new key from random, and makes it the active key.
+func (kr *Keyring) Rotate(random io.Reader, now time.Time) (k *KeyringKey, err error) {
+	id := make([]byte, 8)
+	if _, err = io.ReadFull(random, id); err != nil {
+		return
+	}
+
+	secret := make([]byte, KeyringKeySize)
+	if _, err = io.ReadFull(random, secret); err != nil {
+		return
+	}
+
+	k = &KeyringKey{
+		ID:      hex.EncodeToString(id),
+		Secret:  secret,
+		Created: now,
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	copied := *k
+	kr.keys[k.ID] = &copied
+	err = kr.setActive(k.ID, now)
+	return
+}
+
+// Prune removes retired keys whose grace period has elapsed at now.
+// It returns IDs of removed keys.
+func (kr *Keyring) Prune(now time.Time) (removed []string) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	for id, k := range kr.keys {
+		if !k.IsUsable(now, kr.gracePeriod) {
+			delete(kr.keys, id)
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(removed)
+
+	return
+}
+
+// GetActiveKey returns a copy of the active key.
+func (kr *Keyring) GetActiveKey() (*KeyringKey, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.getKey(kr.active)
+}
+
+// GetKey returns a copy of the key identified by id.
+func (kr *Keyring) GetKey(id string) (*KeyringKey, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.getKey(id)
+}
+
+func (kr *Keyring) getKey(id string) (*KeyringKey, bool) {
+	k, ok := kr.keys[id]
+	if !ok {
+		return nil, false
+	}
+
+	copied := *k
+	return &copied, true
+}
+
+// Keys returns copies of all keys kr has, ordered by creation time.
+func (kr *Keyring) Keys() []*KeyringKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	ret := make([]*KeyringKey, 0, len(kr.keys))
+	for id := range kr.keys {
+		k, _ := kr.getKey(id)
+		ret = append(ret, k)
+	}
+	sort.Sort(keyringKeysByCreated(ret))
+
+	return ret
+}
+
+// Seal encrypts and authenticates plaintext with the active key.
+// additionalData is authenticated but not encrypted, and must be given to Open as is.
+func (kr *Keyring) Seal(plaintext, additionalData []byte, random io.Reader) (sealed []byte, err error) {
+	k, ok := kr.GetActiveKey()
+	if !ok {
+		err = ErrNoActiveKey
+		return
+	}
+
+	aead, err := newKeyringAEAD(k)
+	if err != nil {
+		return
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(random, nonce); err != nil {
+		return
+	}
+
+	// sealed = len(id) || id || nonce || ciphertext
+	sealed = make([]byte, 0, 1+len(k.ID)+len(nonce)+len(plaintext)+aead.Overhead())
+	sealed = append(sealed, byte(len(k.ID)))
+	sealed = append(sealed, k.ID...)
+	sealed = append(sealed, nonce...)
+	sealed = aead.Seal(sealed, nonce, plaintext, additionalData)
+
+	return
+}
+
+// Open decrypts data sealed by Seal.
+// It fails if the key used for sealing has been removed or its grace period has elapsed at now.
+func (kr *Keyring) Open(sealed, additionalData []byte, now time.Time) (plaintext []byte, err error) {
+	if len(sealed) < 1 || len(sealed) < 1+int(sealed[0]) {
+		err = ErrMalformedSealed
+		return
+	}
+
+	idEnd := 1 + int(sealed[0])
+	id := string(sealed[1:idEnd])
+	sealed = sealed[idEnd:]
+
+	k, ok := kr.GetKey(id)
+	if !ok {
+		err = ErrKeyNotFound
+		return
+	} else if !k.IsUsable(now, kr.GetGracePeriod()) {
+		err = ErrKeyRetired
+		return
+	}
+
+	aead, err := newKeyringAEAD(k)
+	if err != nil {
+		return
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		err = ErrMalformedSealed
+		return
+	}
+
+	plaintext, err = aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		err = ErrMalformedSealed
+	}
+	return
+}
+
+type keyringJSON struct {
+	GracePeriod string           `json:"grace_period"`
+	Active      string           `json:"active"`
+	Keys        []keyringKeyJSON `json:"keys"`
+}
+
+type keyringKeyJSON struct {
+	ID      string     `json:"id"`
+	Secret  []byte     `json:"secret"`
+	Created time.Time  `json:"created"`
+	Retired *time.Time `json:"retired,omitempty"`
+}
+
+// MarshalJSON encodes kr, including secrets of its keys, as JSON.
+func (kr *Keyring) MarshalJSON() ([]byte, error) {
+	keys := kr.Keys()
+
+	kr.mu.RLock()
+	v := keyringJSON{
+		GracePeriod: kr.gracePeriod.String(),
+		Active:      kr.active,
+		Keys:        make([]keyringKeyJSON, len(keys)),
+	}
+	kr.mu.RUnlock()
+
+	for i, k := range keys {
+		v.Keys[i] = keyringKeyJSON{
+			ID:      k.ID,
+			Secret:  k.Secret,
+			Created: k.Created,
+		}
+		if k.IsRetired() {
+			retired := k.Retired
+			v.Keys[i].Retired = &retired
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON replaces the contents of kr with the JSON encoded keyring b.
+func (kr *Keyring) UnmarshalJSON(b []byte) error {
+	var v keyringJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	gracePeriod, err := time.ParseDuration(v.GracePeriod)
+	if err != nil {
+		return ErrMalformedKeyring
+	}
+
+	keys := make(map[string]*KeyringKey, len(v.Keys))
+	for _, kj := range v.Keys {
+		if len(kj.Secret) != KeyringKeySize {
+			return ErrInvalidKeySize
+		} else if !isValidKeyringKeyID(kj.ID) {
+			return ErrInvalidKeyID
+		}
+
+		k := &KeyringKey{
+			ID:      kj.ID,
+			Secret:  kj.Secret,
+			Created: kj.Created,
+		}
+		if kj.Retired != nil {
+			k.Retired = *kj.Retired
+		}
+		keys[k.ID] = k
+	}
+
+	if v.Active != "" {
+		if k, ok := keys[v.Active]; !ok || k.IsRetired() {
+			return ErrMalformedKeyring
+		}
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.gracePeriod = gracePeriod
+	kr.active = v.Active
+	kr.keys = keys
+
+	return nil
+}
+
+func isValidKeyringKeyID(id string) bool {
+	return len(id) > 0 && len(id) <= MaxKeyringKeyIDSize
+}
+
+func newKeyringAEAD(k *KeyringKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+type keyringKeysByCreated []*KeyringKey
+
+func (s keyringKeysByCreated) Len() int      { return len(s) }
+func (s keyringKeysByCreated) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s keyringKeysByCreated) Less(i, j int) bool {
+	if s[i].Created.Equal(s[j].Created) {
+		return s[i].ID < s[j].ID
+	}
+	return s[i].Created.Before(s[j].Created)
+}
@@ -0,0 +1,147 @@
+package gopenid
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	now := time.Now()
+	kr := NewKeyring(time.Hour)
+
+	_, err := kr.Seal([]byte("secret"), nil, rand.Reader)
+	assert.Equal(t, err, ErrNoActiveKey)
+
+	first, err := kr.Rotate(rand.Reader, now)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	sealed, err := kr.Seal([]byte("secret"), []byte("handle"), rand.Reader)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	second, err := kr.Rotate(rand.Reader, now)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	if active, ok := kr.GetActiveKey(); assert.True(t, ok) {
+		assert.Equal(t, active.ID, second.ID)
+	}
+	if retired, ok := kr.GetKey(first.ID); assert.True(t, ok) {
+		assert.True(t, retired.IsRetired())
+	}
+
+	// data sealed with the retired key is still usable in the grace period
+	if plaintext, err := kr.Open(sealed, []byte("handle"), now); assert.Nil(t, err) {
+		assert.Equal(t, plaintext, []byte("secret"))
+	}
+
+	_, err = kr.Open(sealed, []byte("another handle"), now)
+	assert.Equal(t, err, ErrMalformedSealed)
+
+	assert.Equal(t, kr.Prune(now.Add(30*time.Minute)), []string(nil))
+	assert.Equal(t, kr.Prune(now.Add(time.Hour)), []string{first.ID})
+
+	_, err = kr.Open(sealed, []byte("handle"), now)
+	assert.Equal(t, err, ErrKeyNotFound)
+}
+
+func TestKeyringGracePeriod(t *testing.T) {
+	kr := NewKeyring(time.Hour)
+
+	first, err := kr.Rotate(rand.Reader, time.Now())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	sealed, err := kr.Seal([]byte("secret"), nil, rand.Reader)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	_, err = kr.Rotate(rand.Reader, time.Now().Add(-2*time.Hour))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	if retired, ok := kr.GetKey(first.ID); assert.True(t, ok) {
+		assert.False(t, retired.IsUsable(time.Now(), kr.GetGracePeriod()))
+	}
+
+	_, err = kr.Open(sealed, nil, time.Now())
+	assert.Equal(t, err, ErrKeyRetired)
+
+	// the grace period is checked at the given time
+	if plaintext, err := kr.Open(sealed, nil, time.Now().Add(-90*time.Minute)); assert.Nil(t, err) {
+		assert.Equal(t, plaintext, []byte("secret"))
+	}
+}
+
+func TestKeyringKeyID(t *testing.T) {
+	kr := NewKeyring(time.Hour)
+	secret := make([]byte, KeyringKeySize)
+
+	assert.Equal(t, kr.AddKey(&KeyringKey{ID: "", Secret: secret}), ErrInvalidKeyID)
+	assert.Equal(t, kr.AddKey(&KeyringKey{ID: strings.Repeat("k", MaxKeyringKeyIDSize+1), Secret: secret}), ErrInvalidKeyID)
+
+	id := strings.Repeat("k", MaxKeyringKeyIDSize)
+	if assert.Nil(t, kr.AddKey(&KeyringKey{ID: id, Secret: secret})) && assert.Nil(t, kr.SetActive(id, time.Now())) {
+		sealed, err := kr.Seal([]byte("secret"), nil, rand.Reader)
+		if assert.Nil(t, err) {
+			if plaintext, err := kr.Open(sealed, nil, time.Now()); assert.Nil(t, err) {
+				assert.Equal(t, plaintext, []byte("secret"))
+			}
+		}
+	}
+
+	assert.Equal(t,
+		kr.UnmarshalJSON([]byte(`{"grace_period":"1h","active":"","keys":[{"id":"","secret":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}]}`)),
+		ErrInvalidKeyID,
+	)
+}
+
+func TestKeyringJSON(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	kr := NewKeyring(24 * time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if _, err := kr.Rotate(rand.Reader, now.Add(time.Duration(i)*time.Minute)); !assert.Nil(t, err) {
+			t.FailNow()
+		}
+	}
+
+	sealed, err := kr.Seal([]byte("secret"), nil, rand.Reader)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	b, err := json.Marshal(kr)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	restored := NewKeyring(0)
+	if !assert.Nil(t, json.Unmarshal(b, restored)) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, restored.GetGracePeriod(), 24*time.Hour)
+	assert.Equal(t, restored.Keys(), kr.Keys())
+
+	if plaintext, err := restored.Open(sealed, nil, now); assert.Nil(t, err) {
+		assert.Equal(t, plaintext, []byte("secret"))
+	}
+
+	assert.Equal(t,
+		restored.UnmarshalJSON([]byte(`{"grace_period":"1h","active":"unknown","keys":[]}`)),
+		ErrMalformedKeyring,
+	)
+}
@@ -0,0 +1,207 @@
+// Package keyservice holds association secrets out of the OpenID Provider process.
+//
+// Service keeps secrets and computes MACs with them, and is exposed over net/rpc.
+// Client talks to Service, and implements gopenid.MACSigner and gopenid.MACKeyManager
+// so that it can be given to provider.Signer.
+package keyservice
+
+import (
+	"crypto/hmac"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+const (
+	ServiceName = "KeyService"
+)
+
+var (
+	ErrUnknownHandle = errors.New("unknown association handle")
+)
+
+// ImportArgs is an argument of Service.Import.
+type ImportArgs struct {
+	Handle    string
+	AssocType string
+	Secret    []byte
+}
+
+// MACArgs is an argument of Service.MAC.
+type MACArgs struct {
+	Handle string
+	Data   []byte
+}
+
+// MACReply is a reply of Service.MAC.
+type MACReply struct {
+	MAC []byte
+}
+
+// DeleteArgs is an argument of Service.Delete.
+type DeleteArgs struct {
+	Handle string
+}
+
+// Empty is a reply of methods which return nothing.
+type Empty struct{}
+
+type key struct {
+	assocType gopenid.AssocType
+	secret    []byte
+}
+
+// Service holds association secrets, and computes MACs with them.
+type Service struct {
+	mu   sync.RWMutex
+	keys map[string]key
+}
+
+// NewService returns a new empty Service.
+func NewService() *Service {
+	return &Service{
+		keys: make(map[string]key),
+	}
+}
+
+// Import stores the secret of an association.
+func (s *Service) Import(args *ImportArgs, reply *Empty) error {
+	assocType, err := gopenid.GetAssocTypeByName(args.AssocType)
+	if err != nil {
+		return err
+	}
+
+	secret := make([]byte, len(args.Secret))
+	copy(secret, args.Secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[args.Handle] = key{
+		assocType: assocType,
+		secret:    secret,
+	}
+	return nil
+}
+
+// MAC computes HMAC of args.Data with the secret of the association args.Handle.
+func (s *Service) MAC(args *MACArgs, reply *MACReply) error {
+	s.mu.RLock()
+	k, ok := s.keys[args.Handle]
+	s.mu.RUnlock()
+
+	if !ok {
+		return ErrUnknownHandle
+	}
+
+	mac := hmac.New(k.assocType.Hash, k.secret)
+	mac.Write(args.Data)
+	reply.MAC = mac.Sum(nil)
+
+	return nil
+}
+
+// Delete forgets the secret of the association args.Handle.
+func (s *Service) Delete(args *DeleteArgs, reply *Empty) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, args.Handle)
+	return nil
+}
+
+// NewServer returns a rpc.Server serving s.
+func NewServer(s *Service) (*rpc.Server, error) {
+	server := rpc.NewServer()
+	if err := server.RegisterName(ServiceName, s); err != nil {
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// Serve accepts connections on l, and serves s on each of them.
+func Serve(l net.Listener, s *Service) error {
+	server, err := NewServer(s)
+	if err != nil {
+		return err
+	}
+
+	server.Accept(l)
+	return nil
+}
+
+// Client is a client of Service.
+type Client struct {
+	client *rpc.Client
+}
+
+// Dial connects to Service listening at address.
+func Dial(network, address string) (*Client, error) {
+	client, err := rpc.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		client: client,
+	}, nil
+}
+
+// NewClient returns a new Client talking to Service over conn.
+func NewClient(conn io.ReadWriteCloser) *Client {
+	return &Client{
+		client: rpc.NewClient(conn),
+	}
+}
+
+// MAC asks Service to compute MAC of data with the secret of assoc.
+func (c *Client) MAC(assoc *gopenid.Association, data []byte) ([]byte, error) {
+	var reply MACReply
+
+	err := c.call("MAC", &MACArgs{
+		Handle: assoc.GetHandle(),
+		Data:   data,
+	}, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.MAC, nil
+}
+
+// ImportKey hands the secret of assoc over to Service.
+func (c *Client) ImportKey(assoc *gopenid.Association) error {
+	assocType := assoc.GetAssocType()
+
+	return c.call("Import", &ImportArgs{
+		Handle:    assoc.GetHandle(),
+		AssocType: assocType.Name(),
+		Secret:    assoc.GetSecret(),
+	}, &Empty{})
+}
+
+// DeleteKey makes Service forget the secret of the association handle.
+func (c *Client) DeleteKey(handle string) error {
+	return c.call("Delete", &DeleteArgs{
+		Handle: handle,
+	}, &Empty{})
+}
+
+// Close closes the connection to Service.
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+func (c *Client) call(method string, args interface{}, reply interface{}) error {
+	err := c.client.Call(ServiceName+"."+method, args, reply)
+	if serverErr, ok := err.(rpc.ServerError); ok && string(serverErr) == ErrUnknownHandle.Error() {
+		return ErrUnknownHandle
+	}
+
+	return err
+}
@@ -0,0 +1,90 @@
+package keyservice
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T) *Client {
+	server, err := NewServer(NewService())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+
+	return NewClient(clientConn)
+}
+
+func TestClient(t *testing.T) {
+	client := newTestClient(t)
+	defer client.Close()
+
+	secret := make([]byte, gopenid.DefaultAssoc.GetSecretSize())
+	_, err := io.ReadFull(rand.Reader, secret)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assoc := gopenid.NewAssociation(gopenid.DefaultAssoc, "handle", secret, time.Now().Add(time.Hour), false)
+
+	_, err = client.MAC(assoc, []byte("data"))
+	assert.Equal(t, err, ErrUnknownHandle)
+
+	if !assert.Nil(t, client.ImportKey(assoc)) {
+		t.FailNow()
+	}
+
+	// the key service computes the same MAC as in-process HMAC
+	expected, _ := gopenid.HMACSigner{}.MAC(assoc, []byte("data"))
+	withoutSecret := gopenid.NewAssociation(gopenid.DefaultAssoc, "handle", nil, time.Now().Add(time.Hour), false)
+	if mac, err := client.MAC(withoutSecret, []byte("data")); assert.Nil(t, err) {
+		assert.Equal(t, mac, expected)
+	}
+
+	if !assert.Nil(t, client.DeleteKey("handle")) {
+		t.FailNow()
+	}
+	_, err = client.MAC(assoc, []byte("data"))
+	assert.Equal(t, err, ErrUnknownHandle)
+}
+
+func TestAssociationSign(t *testing.T) {
+	client := newTestClient(t)
+	defer client.Close()
+
+	secret := make([]byte, gopenid.DefaultAssoc.GetSecretSize())
+	_, err := io.ReadFull(rand.Reader, secret)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assoc := gopenid.NewAssociation(gopenid.DefaultAssoc, "handle", secret, time.Now().Add(time.Hour), false)
+	if !assert.Nil(t, client.ImportKey(assoc)) {
+		t.FailNow()
+	}
+
+	local := gopenid.NewMessage(gopenid.NsOpenID20)
+	local.AddArg(gopenid.NewMessageKey(gopenid.NsOpenID20, "mode"), "id_res")
+	remote := local.Copy()
+
+	if !assert.Nil(t, assoc.Sign(local, []string{"mode"})) {
+		t.FailNow()
+	}
+
+	withoutSecret := gopenid.NewAssociation(gopenid.DefaultAssoc, "handle", nil, time.Now().Add(time.Hour), false)
+	withoutSecret.SetMACSigner(client)
+	if !assert.Nil(t, withoutSecret.Sign(remote, []string{"mode"})) {
+		t.FailNow()
+	}
+
+	key := gopenid.NewMessageKey(gopenid.NsOpenID20, "sig")
+	expected, _ := local.GetArg(key)
+	actual, _ := remote.GetArg(key)
+	assert.Equal(t, actual, expected)
+}
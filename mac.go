@@ -0,0 +1,37 @@
+package gopenid
+
+import (
+	"crypto/hmac"
+)
+
+var (
+	DefaultMACSigner MACSigner = HMACSigner{}
+)
+
+// MACSigner computes message authentication codes on behalf of associations.
+//
+// Implementations may compute them in-process from Association.GetSecret,
+// or delegate them to an external key service holding the secrets.
+type MACSigner interface {
+	MAC(assoc *Association, data []byte) ([]byte, error)
+}
+
+// MACKeyManager is implemented by MACSigner which keeps association secrets on its own.
+//
+// Secrets are handed over by ImportKey when an association is created,
+// so that they never have to be persisted in Store.
+type MACKeyManager interface {
+	MACSigner
+	ImportKey(assoc *Association) error
+	DeleteKey(handle string) error
+}
+
+// HMACSigner computes HMAC in-process from the secret of association.
+type HMACSigner struct{}
+
+// MAC returns HMAC of data keyed with the secret of assoc.
+func (HMACSigner) MAC(assoc *Association, data []byte) ([]byte, error) {
+	mac := hmac.New(assoc.assocType.hashFunc, assoc.GetSecret())
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
@@ -24,6 +24,18 @@ var (
 	ErrKeyContainsColon   = errors.New("key contains colon")
 	ErrKeyContainsNewLine = errors.New("key contains new line")
 	ErrValueNotFound      = errors.New("value not found")
+	ErrTooManyArgs        = errors.New("too many arguments")
+	ErrKeyTooLong         = errors.New("key too long")
+	ErrValueTooLong       = errors.New("value too long")
+	ErrTooManyAliases     = errors.New("too many namespace aliases")
+
+	// DefaultMessageLimits are limits MessageFromQuery enforces.
+	DefaultMessageLimits = MessageLimits{
+		MaxArgs:        256,
+		MaxKeyLength:   256,
+		MaxValueLength: 8192,
+		MaxAliases:     32,
+	}
 
 	protocolFields = []string{
 		"assoc_handle",
@@ -53,6 +65,59 @@ var (
 	}
 )
 
+// fieldOrder is the order fields of the OpenID namespace are encoded in. Other fields follow them sorted.
+var fieldOrder = map[string]int{}
+
+func init() {
+	for i, field := range []string{
+		"mode",
+		"error",
+		"error_code",
+		"contact",
+		"reference",
+		"op_endpoint",
+		"claimed_id",
+		"identity",
+		"return_to",
+		"realm",
+		"trust_root",
+		"response_nonce",
+		"invalidate_handle",
+		"is_valid",
+		"assoc_handle",
+		"session_type",
+		"assoc_type",
+		"expires_in",
+		"mac_key",
+		"dh_modulus",
+		"dh_gen",
+		"dh_consumer_public",
+		"dh_server_public",
+		"enc_mac_key",
+		"user_setup_url",
+		"signed",
+		"sig",
+	} {
+		fieldOrder[field] = i
+	}
+}
+
+// byFieldOrder sorts fields of the OpenID namespace by fieldOrder.
+type byFieldOrder []string
+
+func (s byFieldOrder) Len() int      { return len(s) }
+func (s byFieldOrder) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byFieldOrder) Less(i, j int) bool {
+	oi, iKnown := fieldOrder[s[i]]
+	oj, jKnown := fieldOrder[s[j]]
+	if iKnown && jKnown {
+		return oi < oj
+	} else if iKnown != jKnown {
+		return iKnown
+	}
+	return s[i] < s[j]
+}
+
 // NamespaceURI represents URI for Namespace.
 type NamespaceURI string
 
@@ -98,18 +163,33 @@ func (v MessageValue) Bytes() []byte {
 	return []byte(v)
 }
 
+// IsValidNamespaceAlias reports whether alias can be declared as a namespace alias.
+func IsValidNamespaceAlias(alias string) bool {
+	if alias == "" || strings.ContainsAny(alias, ".,:\n") {
+		// A namespace alias MUST NOT contain a period
+		return false
+	}
+
+	// The namespace alias is not allowed to be a protocol field
+	idx := sort.SearchStrings(protocolFields, alias)
+	return idx == len(protocolFields) || protocolFields[idx] != alias
+}
+
 // Message represents OpenID protocol message.
+//
+// Message is used by pointer. All of its methods are safe for concurrent use,
+// and Copy returns a Message sharing nothing with the original.
 type Message struct {
+	mu            sync.RWMutex
 	namespace     NamespaceURI
 	nsuri2nsalias map[NamespaceURI]string
 	nsalias2nsuri map[string]NamespaceURI
 	args          map[MessageKey]MessageValue
-	sync.Mutex
 }
 
 // NewMessage returns a new Message with the given NamespaceURI.
-func NewMessage(ns NamespaceURI) Message {
-	return Message{
+func NewMessage(ns NamespaceURI) *Message {
+	return &Message{
 		namespace:     ns,
 		nsuri2nsalias: make(map[NamespaceURI]string),
 		nsalias2nsuri: make(map[string]NamespaceURI),
@@ -119,6 +199,9 @@ func NewMessage(ns NamespaceURI) Message {
 
 // GetOpenIDNamespace returns NamespaceURI of m.
 func (m *Message) GetOpenIDNamespace() NamespaceURI {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	return m.namespace
 }
 
@@ -129,6 +212,9 @@ func (m *Message) GetNamespaceURI(alias string) (NamespaceURI, bool) {
 		return m.GetOpenIDNamespace(), true
 	}
 
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	nsuri, ok := m.nsalias2nsuri[alias]
 	return nsuri, ok
 }
@@ -136,7 +222,15 @@ func (m *Message) GetNamespaceURI(alias string) (NamespaceURI, bool) {
 // GetNamespaceAlias returns alias is pointing to the given NamespaceURI.
 // If alias does not exist, GetNamespaceURI returns false as 2nd return value.
 func (m *Message) GetNamespaceAlias(uri NamespaceURI) (string, bool) {
-	if uri == m.GetOpenIDNamespace() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.namespaceAlias(uri)
+}
+
+// namespaceAlias is GetNamespaceAlias for callers holding m.mu.
+func (m *Message) namespaceAlias(uri NamespaceURI) (string, bool) {
+	if uri == m.namespace {
 		return "", true
 	}
 
@@ -144,8 +238,29 @@ func (m *Message) GetNamespaceAlias(uri NamespaceURI) (string, bool) {
 	return nsalias, ok
 }
 
+// GetNamespaceURIs returns NamespaceURIs declared with aliases, ordered by their aliases.
+func (m *Message) GetNamespaceURIs() []NamespaceURI {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	aliases := make([]string, 0, len(m.nsalias2nsuri))
+	for alias := range m.nsalias2nsuri {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	ret := make([]NamespaceURI, len(aliases))
+	for i, alias := range aliases {
+		ret[i] = m.nsalias2nsuri[alias]
+	}
+	return ret
+}
+
 // SetNamespaceAlias is a function to register relationship between alias and NamespaceURI.
 func (m *Message) SetNamespaceAlias(alias string, uri NamespaceURI) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.nsuri2nsalias[uri] = alias
 	m.nsalias2nsuri[alias] = uri
 }
@@ -153,20 +268,28 @@ func (m *Message) SetNamespaceAlias(alias string, uri NamespaceURI) {
 // GetArg returns value of given k.
 // If value does not exist, GetArg returns false as 2nd return value.
 func (m *Message) GetArg(k MessageKey) (MessageValue, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	v, ok := m.args[k]
 	return v, ok
 }
 
 // AddArg registers given the v as value of k.
 func (m *Message) AddArg(k MessageKey, v MessageValue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.args[k] = v
 }
 
 // GetArgs returns the subset of m as map[MessageKey]MessageValue.
 // Returned subset contains values related to the given NamespaceURI.
 func (m *Message) GetArgs(nsuri NamespaceURI) map[MessageKey]MessageValue {
-	ret := make(map[MessageKey]MessageValue)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
+	ret := make(map[MessageKey]MessageValue)
 	for k, v := range m.args {
 		if k.GetNamespace() == nsuri {
 			ret[k] = v
@@ -177,6 +300,13 @@ func (m *Message) GetArgs(nsuri NamespaceURI) map[MessageKey]MessageValue {
 
 // ToQuery returns the m as url.Values.
 func (m *Message) ToQuery() url.Values {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.toQuery()
+}
+
+func (m *Message) toQuery() url.Values {
 	query := url.Values{
 		"openid.ns": []string{m.namespace.String()},
 	}
@@ -187,7 +317,7 @@ func (m *Message) ToQuery() url.Values {
 
 	for key, value := range m.args {
 		var queryKey string
-		if alias, _ := m.GetNamespaceAlias(key.GetNamespace()); alias == "" {
+		if alias, _ := m.namespaceAlias(key.GetNamespace()); alias == "" {
 			queryKey = fmt.Sprintf("openid.%s", key.GetKey())
 		} else {
 			queryKey = fmt.Sprintf("openid.%s.%s", alias, key.GetKey())
@@ -198,36 +328,93 @@ func (m *Message) ToQuery() url.Values {
 	return query
 }
 
-// Keys returns all of keys m has.
+// Keys returns all of keys m has, in the order messages are encoded: openid.ns, fields of
+// the OpenID namespace in the order of fieldOrder and then sorted, and extensions sorted by
+// alias, each declaration followed by its sorted fields.
 func (m *Message) Keys() []string {
-	ret := make([]string, 1, len(m.args)+1)
-	ret[0] = "openid.ns"
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-	for key := range m.args {
-		parts := make([]string, 0, 3)
-		parts = append(parts, "openid")
+	return m.keys()
+}
 
-		if nsalias, ok := m.GetNamespaceAlias(key.GetNamespace()); !ok {
+func (m *Message) keys() []string {
+	var (
+		fields     []string
+		extensions = make(map[string][]string)
+	)
+	for key := range m.args {
+		nsalias, ok := m.namespaceAlias(key.GetNamespace())
+		if !ok {
 			continue
-		} else if nsalias != "" {
-			parts = append(parts, nsalias)
+		} else if nsalias == "" {
+			fields = append(fields, key.GetKey())
+		} else {
+			extensions[nsalias] = append(extensions[nsalias], key.GetKey())
 		}
+	}
+	sort.Sort(byFieldOrder(fields))
 
-		parts = append(parts, key.GetKey())
+	ret := make([]string, 0, len(m.args)+len(extensions)+1)
+	ret = append(ret, "openid.ns")
+	for _, field := range fields {
+		ret = append(ret, "openid."+field)
+	}
 
-		ret = append(
-			ret,
-			strings.Join(parts, "."),
-		)
+	aliases := make([]string, 0, len(extensions))
+	for nsalias := range extensions {
+		aliases = append(aliases, nsalias)
+	}
+	sort.Strings(aliases)
+
+	for _, nsalias := range aliases {
+		ret = append(ret, "openid.ns."+nsalias)
+
+		keys := extensions[nsalias]
+		sort.Strings(keys)
+		for _, key := range keys {
+			ret = append(ret, "openid."+nsalias+"."+key)
+		}
 	}
 
 	return ret
+}
+
+// Encode returns m in the application/x-www-form-urlencoded form, in the order of Keys.
+// Namespace declarations without fields follow it sorted.
+func (m *Message) Encode() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	query := m.toQuery()
+	parts := make([]string, 0, len(query))
+	encode := func(key string) {
+		parts = append(parts, url.QueryEscape(key)+"="+url.QueryEscape(query.Get(key)))
+		delete(query, key)
+	}
+
+	for _, key := range m.keys() {
+		encode(key)
+	}
+
+	rest := make([]string, 0, len(query))
+	for key := range query {
+		rest = append(rest, key)
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		encode(key)
+	}
 
+	return strings.Join(parts, "&")
 }
 
 // ToKeyValue returns part of m as KeyValue format.
 // Returnd KeyValue follows the given order.
 func (m *Message) ToKeyValue(order []string) (b []byte, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	validator := func(str string, isKey bool) error {
 		if isKey && strings.Index(str, ":") > -1 {
 			return ErrKeyContainsColon
@@ -298,9 +485,9 @@ func (m *Message) ToKeyValue(order []string) (b []byte, err error) {
 }
 
 // Copy returns copy of m.
-func (m *Message) Copy() Message {
-	m.Lock()
-	defer m.Unlock()
+func (m *Message) Copy() *Message {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
 	msg := NewMessage(m.namespace)
 
@@ -319,12 +506,73 @@ func (m *Message) Copy() Message {
 	return msg
 }
 
+// MarshalBinary encodes m in the application/x-www-form-urlencoded form of Encode.
+func (m *Message) MarshalBinary() ([]byte, error) {
+	return []byte(m.Encode()), nil
+}
+
+// UnmarshalBinary replaces m with the message encoded by MarshalBinary.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	query, err := url.ParseQuery(string(data))
+	if err != nil {
+		return ErrMalformedMessage
+	}
+
+	// the encoded message was accepted before, so it is not limited again
+	msg, err := MessageFromQueryWithLimits(query, MessageLimits{})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.namespace = msg.namespace
+	m.nsuri2nsalias = msg.nsuri2nsalias
+	m.nsalias2nsuri = msg.nsalias2nsuri
+	m.args = msg.args
+	return nil
+}
+
+// MessageLimits limits sizes of inbound messages, so that crafted messages cannot make
+// parsers allocate large amounts of memory. Zero fields are not limited.
+type MessageLimits struct {
+	// MaxArgs is the maximum number of openid.* arguments, including namespace declarations.
+	MaxArgs int
+	// MaxKeyLength is the maximum length of keys, including the "openid." prefix.
+	MaxKeyLength int
+	// MaxValueLength is the maximum length of values.
+	MaxValueLength int
+	// MaxAliases is the maximum number of namespace aliases declared.
+	MaxAliases int
+}
+
+// check returns an error if an argument of key and value makes a message of numArgs arguments exceed limits.
+func (limits MessageLimits) check(numArgs int, key, value string) error {
+	if limits.MaxArgs > 0 && numArgs > limits.MaxArgs {
+		return ErrTooManyArgs
+	} else if limits.MaxKeyLength > 0 && len(key) > limits.MaxKeyLength {
+		return ErrKeyTooLong
+	} else if limits.MaxValueLength > 0 && len(value) > limits.MaxValueLength {
+		return ErrValueTooLong
+	}
+	return nil
+}
+
 // MessageFromQuery returns a new message as a result of parsing the given query.
-func MessageFromQuery(req url.Values) (msg Message, err error) {
+// It enforces DefaultMessageLimits.
+func MessageFromQuery(req url.Values) (msg *Message, err error) {
+	return MessageFromQueryWithLimits(req, DefaultMessageLimits)
+}
+
+// MessageFromQueryWithLimits returns a new message as a result of parsing the given query.
+// It returns ErrTooManyArgs, ErrKeyTooLong, ErrValueTooLong or ErrTooManyAliases if the query exceeds limits.
+func MessageFromQueryWithLimits(req url.Values, limits MessageLimits) (msg *Message, err error) {
 	var (
-		ns    NamespaceURI
-		nsmap = make(map[string]NamespaceURI)
-		args  = make(map[string]map[string]string)
+		ns      NamespaceURI
+		nsmap   = make(map[string]NamespaceURI)
+		args    = make(map[string]map[string]string)
+		numArgs int
 	)
 
 	for key, values := range req {
@@ -336,6 +584,11 @@ func MessageFromQuery(req url.Values) (msg Message, err error) {
 			continue
 		}
 
+		numArgs++
+		if err = limits.check(numArgs, key, values[0]); err != nil {
+			return
+		}
+
 		var (
 			parts = strings.SplitN(key[7:], ".", 2)
 			value = values[0]
@@ -354,16 +607,15 @@ func MessageFromQuery(req url.Values) (msg Message, err error) {
 		if nsalias == "" && key == "ns" {
 			ns = NamespaceURI(value)
 		} else if nsalias == "ns" {
-			if strings.Index(key, ".") >= 0 {
-				// A namespace alias MUST NOT contain a period
-				err = ErrMalformedMessage
-				return
-			} else if idx := sort.SearchStrings(protocolFields, key); protocolFields[idx] == key {
-				// The namespace alias is not allowed
+			if !IsValidNamespaceAlias(key) {
 				err = ErrMalformedMessage
 				return
 			}
 			nsmap[key] = NamespaceURI(value)
+			if limits.MaxAliases > 0 && len(nsmap) > limits.MaxAliases {
+				err = ErrTooManyAliases
+				return
+			}
 		} else {
 			if _, ok := args[nsalias]; !ok {
 				args[nsalias] = make(map[string]string)
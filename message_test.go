@@ -5,14 +5,17 @@ import (
 	"fmt"
 	"net/url"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 type messageFromQueryCase struct {
 	query    url.Values
-	expected Message
+	expected *Message
 	err      error
 }
 
@@ -27,7 +30,7 @@ var (
 					"checkid_immediate",
 				},
 			},
-			expected: Message{
+			expected: &Message{
 				namespace:     NsOpenID20,
 				nsuri2nsalias: make(map[NamespaceURI]string),
 				nsalias2nsuri: make(map[string]NamespaceURI),
@@ -48,7 +51,7 @@ var (
 					"value",
 				},
 			},
-			expected: Message{
+			expected: &Message{
 				namespace: NsOpenID20,
 				nsuri2nsalias: map[NamespaceURI]string{
 					"http://example.com/": "example",
@@ -101,7 +104,7 @@ var (
 					"checkid_immediate",
 				},
 			},
-			expected: Message{
+			expected: &Message{
 				namespace:     NsOpenID11,
 				nsuri2nsalias: make(map[NamespaceURI]string),
 				nsalias2nsuri: make(map[string]NamespaceURI),
@@ -136,13 +139,79 @@ func TestMessageFromQuery(t *testing.T) {
 	}
 }
 
+func TestMessageFromQueryWithLimits(t *testing.T) {
+	limits := MessageLimits{
+		MaxArgs:        4,
+		MaxKeyLength:   20,
+		MaxValueLength: 10,
+		MaxAliases:     1,
+	}
+
+	for _, testCase := range []struct {
+		query url.Values
+		err   error
+	}{
+		{
+			url.Values{
+				"openid.mode":   []string{"associate"},
+				"openid.ns.ext": []string{"ext"},
+				"openid.ext.a":  []string{"0123456789"},
+				"unrelated":     []string{strings.Repeat("x", 100)},
+			},
+			nil,
+		},
+		{
+			url.Values{
+				"openid.mode":  []string{"associate"},
+				"openid.ext.a": []string{"a"},
+				"openid.ext.b": []string{"b"},
+				"openid.ext.c": []string{"c"},
+				"openid.ext.d": []string{"d"},
+			},
+			ErrTooManyArgs,
+		},
+		{
+			url.Values{
+				"openid.ext." + strings.Repeat("k", 10): []string{"a"},
+			},
+			ErrKeyTooLong,
+		},
+		{
+			url.Values{
+				"openid.mode": []string{"01234567890"},
+			},
+			ErrValueTooLong,
+		},
+		{
+			url.Values{
+				"openid.ns.a": []string{"a"},
+				"openid.ns.b": []string{"b"},
+			},
+			ErrTooManyAliases,
+		},
+	} {
+		_, err := MessageFromQueryWithLimits(testCase.query, limits)
+		assert.Equal(t, err, testCase.err, "%v", testCase.query)
+	}
+
+	// unlimited
+	query := make(url.Values)
+	for i := 0; i < DefaultMessageLimits.MaxArgs+1; i++ {
+		query.Set(fmt.Sprintf("openid.ext.%d", i), "value")
+	}
+	_, err := MessageFromQuery(query)
+	assert.Equal(t, err, ErrTooManyArgs)
+	_, err = MessageFromQueryWithLimits(query, MessageLimits{})
+	assert.Nil(t, err)
+}
+
 func TestMessage(t *testing.T) {
 	var (
 		NsExt   NamespaceURI = "http://example.com/"
 		NsDummy NamespaceURI = "http://dummy.example.com/"
 	)
 
-	msg := Message{
+	msg := &Message{
 		namespace: NsOpenID20,
 		nsuri2nsalias: map[NamespaceURI]string{
 			"http://example.com/": "example",
@@ -211,6 +280,29 @@ func TestMessage(t *testing.T) {
 		},
 	)
 
+	assert.Equal(t,
+		msg.Keys(),
+		[]string{
+			"openid.ns",
+			"openid.mode",
+			"openid.return_to",
+			"openid.ns.example",
+			"openid.example.foo",
+			"openid.example.hoge",
+		},
+	)
+	msg.SetNamespaceAlias("unused", NsDummy)
+	assert.Equal(t,
+		msg.Encode(),
+		"openid.ns=http%3A%2F%2Fspecs.openid.net%2Fauth%2F2.0"+
+			"&openid.mode=checkid_immediate"+
+			"&openid.return_to=http%3A%2F%2Fwww.example.com%2F"+
+			"&openid.ns.example=http%3A%2F%2Fexample.com%2F"+
+			"&openid.example.foo=bar"+
+			"&openid.example.hoge=fuga"+
+			"&openid.ns.unused=http%3A%2F%2Fdummy.example.com%2F",
+	)
+
 	if kv, err := msg.ToKeyValue([]string{"openid.ns", "openid.return_to"}); assert.Nil(t, err) {
 		expected := bytes.Join([][]byte{
 			[]byte(fmt.Sprintf("ns:%s", NsOpenID20.String())),
@@ -225,3 +317,79 @@ func TestMessage(t *testing.T) {
 		msg.Copy(),
 	)
 }
+
+func TestMessageConcurrency(t *testing.T) {
+	secret := make([]byte, DefaultAssoc.GetSecretSize())
+	assoc := NewAssociation(DefaultAssoc, "handle", secret, time.Now().Add(time.Hour), true)
+
+	msg := NewMessage(NsOpenID20)
+	msg.AddArg(NewMessageKey(NsOpenID20, "mode"), "id_res")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(4)
+
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				msg.AddArg(NewMessageKey(NsOpenID20, fmt.Sprintf("key%d_%d", i, j)), "value")
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				msg.SetNamespaceAlias(fmt.Sprintf("ext%d", i), NamespaceURI(fmt.Sprintf("http://example.com/%d", i)))
+				msg.ToQuery()
+				msg.Keys()
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.Nil(t, assoc.Sign(msg, []string{"mode"}))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				msg.Copy().AddArg(NewMessageKey(NsOpenID20, "copied"), "value")
+				msg.GetArgs(NsOpenID20)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, copied := msg.GetArg(NewMessageKey(NsOpenID20, "copied"))
+	assert.False(t, copied)
+	assert.Len(t, msg.GetArgs(NsOpenID20), 8*100+4)
+	assert.Len(t, msg.GetNamespaceURIs(), 8)
+}
+
+func TestMessageMarshalBinary(t *testing.T) {
+	msg := NewMessage(NsOpenID20)
+	msg.SetNamespaceAlias("ext", "http://example.com/ext")
+	msg.AddArg(NewMessageKey(NsOpenID20, "mode"), "checkid_setup")
+	msg.AddArg(NewMessageKey(NsOpenID20, "return_to"), "http://rp.example.com/return?a=b&c=d")
+	msg.AddArg(NewMessageKey("http://example.com/ext", "name"), "value:with\nnewline")
+
+	b, err := msg.MarshalBinary()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	decoded := new(Message)
+	if assert.Nil(t, decoded.UnmarshalBinary(b)) {
+		assert.Equal(t, decoded, msg)
+	}
+
+	// OpenID 1.1 messages keep their namespace
+	msg = NewMessage(NsOpenID11)
+	msg.AddArg(NewMessageKey(NsOpenID11, "mode"), "checkid_setup")
+	b, _ = msg.MarshalBinary()
+	if assert.Nil(t, decoded.UnmarshalBinary(b)) {
+		assert.Equal(t, decoded, msg)
+	}
+
+	assert.Equal(t, decoded.UnmarshalBinary([]byte("openid.ns=http://example.com/")), ErrUnsupportedVersion)
+	assert.Equal(t, decoded.UnmarshalBinary([]byte("%zz")), ErrMalformedMessage)
+}
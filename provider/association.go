@@ -0,0 +1,146 @@
+package provider
+
+import (
+	"errors"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+var (
+	ErrNoAssociationPairs      = errors.New("no association pairs allowed")
+	ErrInvalidAssociationPair  = errors.New("session type does not support association type")
+	ErrAssociationPairRejected = errors.New("combination of session type and association type is not allowed")
+	ErrInsecureNoEncryption    = errors.New("no-encryption session is not allowed over an insecure transport")
+	ErrNoAvailableAssociation  = errors.New("no allowed association type is registered")
+
+	DefaultAssociationPairs = []AssociationPair{
+		AssociationPair{
+			SessionType: gopenid.SessionDhSha256,
+			AssocType:   gopenid.AssocHmacSha256,
+		},
+		AssociationPair{
+			SessionType: gopenid.SessionNoEncryption,
+			AssocType:   gopenid.AssocHmacSha256,
+		},
+		AssociationPair{
+			SessionType: gopenid.SessionDhSha1,
+			AssocType:   gopenid.AssocHmacSha1,
+		},
+		AssociationPair{
+			SessionType: gopenid.SessionNoEncryption,
+			AssocType:   gopenid.AssocHmacSha1,
+		},
+	}
+)
+
+// AssociationPair is a combination of session type and association type
+// which the provider establishes associations with.
+type AssociationPair struct {
+	SessionType gopenid.SessionType
+	AssocType   gopenid.AssocType
+}
+
+// Validate returns an error if SessionType cannot transport MAC key of AssocType.
+func (pair *AssociationPair) Validate() error {
+	if !pair.SessionType.Supports(pair.AssocType) {
+		return ErrInvalidAssociationPair
+	}
+
+	return nil
+}
+
+// isRegistered reports whether both types of pair are registered in gopenid.DefaultRegistry.
+func (pair *AssociationPair) isRegistered() bool {
+	if _, err := gopenid.DefaultRegistry.GetSessionType(pair.SessionType.Name()); err != nil {
+		return false
+	} else if _, err := gopenid.DefaultRegistry.GetAssocType(pair.AssocType.Name()); err != nil {
+		return false
+	}
+
+	return true
+}
+
+func (pair *AssociationPair) matches(sessionType gopenid.SessionType, assocType gopenid.AssocType) bool {
+	return pair.SessionType.Name() == sessionType.Name() && pair.AssocType.Name() == assocType.Name()
+}
+
+// associationPairs is a list of AssociationPair ordered by preference.
+type associationPairs []AssociationPair
+
+func newAssociationPairs(pairs []AssociationPair) (associationPairs, error) {
+	if len(pairs) == 0 {
+		return nil, ErrNoAssociationPairs
+	}
+
+	for _, pair := range pairs {
+		if err := pair.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := make(associationPairs, len(pairs))
+	copy(ret, pairs)
+	return ret, nil
+}
+
+func (pairs associationPairs) available() associationPairs {
+	ret := make(associationPairs, 0, len(pairs))
+	for _, pair := range pairs {
+		if pair.isRegistered() {
+			ret = append(ret, pair)
+		}
+	}
+
+	return ret
+}
+
+func (pairs associationPairs) isAllowed(sessionType gopenid.SessionType, assocType gopenid.AssocType) bool {
+	for _, pair := range pairs.available() {
+		if pair.matches(sessionType, assocType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// preferred returns the most preferred pair which is close to what the relying party requested.
+// A pair using the requested session type wins over one using the requested association type.
+// If no pair is registered, it returns false as 2nd return value.
+func (pairs associationPairs) preferred(sessionType gopenid.SessionType, assocType gopenid.AssocType) (AssociationPair, bool) {
+	available := pairs.available()
+	if len(available) == 0 {
+		return AssociationPair{}, false
+	}
+
+	for _, pair := range available {
+		if pair.SessionType.Name() == sessionType.Name() {
+			return pair, true
+		}
+	}
+
+	for _, pair := range available {
+		if pair.AssocType.Name() == assocType.Name() {
+			return pair, true
+		}
+	}
+
+	return available[0], true
+}
+
+// preferredEncrypted is same as preferred, but it never returns a pair using no-encryption session.
+// If no such pair is allowed, it returns false as 2nd return value.
+func (pairs associationPairs) preferredEncrypted(assocType gopenid.AssocType) (AssociationPair, bool) {
+	encrypted := make(associationPairs, 0, len(pairs))
+	for _, pair := range pairs.available() {
+		if pair.SessionType.Name() != gopenid.SessionNoEncryption.Name() {
+			encrypted = append(encrypted, pair)
+		}
+	}
+
+	if len(encrypted) == 0 {
+		return AssociationPair{}, false
+	}
+
+	return encrypted.preferred(gopenid.SessionType{}, assocType)
+}
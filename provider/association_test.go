@@ -0,0 +1,233 @@
+package provider
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssociationPairs(t *testing.T) {
+	_, err := newAssociationPairs(nil)
+	assert.Equal(t, err, ErrNoAssociationPairs)
+
+	_, err = newAssociationPairs([]AssociationPair{
+		AssociationPair{
+			SessionType: gopenid.SessionDhSha1,
+			AssocType:   gopenid.AssocHmacSha256,
+		},
+	})
+	assert.Equal(t, err, ErrInvalidAssociationPair)
+
+	pairs, err := newAssociationPairs(DefaultAssociationPairs)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.True(t, pairs.isAllowed(gopenid.SessionDhSha1, gopenid.AssocHmacSha1))
+	assert.False(t, pairs.isAllowed(gopenid.SessionDhSha1, gopenid.AssocHmacSha256))
+
+	for _, testCase := range []struct {
+		sessionType gopenid.SessionType
+		assocType   gopenid.AssocType
+		expected    AssociationPair
+	}{
+		{gopenid.SessionDhSha1, gopenid.AssocHmacSha256, DefaultAssociationPairs[2]},
+		{gopenid.SessionType{}, gopenid.AssocHmacSha1, DefaultAssociationPairs[2]},
+		{gopenid.SessionType{}, gopenid.AssocType{}, DefaultAssociationPairs[0]},
+	} {
+		preferred, ok := pairs.preferred(testCase.sessionType, testCase.assocType)
+		if assert.True(t, ok) {
+			assert.True(t, preferred.matches(testCase.expected.SessionType, testCase.expected.AssocType))
+		}
+	}
+
+	// pairs of types missing in gopenid.DefaultRegistry are never preferred
+	unregisteredAssoc := gopenid.NewAssocType("HMAC-UNREGISTERED", sha256.New, sha256.Size)
+	pairs, err = newAssociationPairs([]AssociationPair{
+		AssociationPair{
+			SessionType: gopenid.NewSessionType("unregistered", unregisteredAssoc),
+			AssocType:   unregisteredAssoc,
+		},
+	})
+	if assert.Nil(t, err) {
+		_, ok := pairs.preferred(gopenid.SessionType{}, gopenid.AssocType{})
+		assert.False(t, ok)
+		_, ok = pairs.preferredEncrypted(gopenid.AssocType{})
+		assert.False(t, ok)
+	}
+}
+
+func TestSignerUsesAllowedAssociations(t *testing.T) {
+	p := NewProvider(endpoint, newMemoryStore(), time.Hour, rand.Reader)
+	err := p.SetAllowedAssociations(
+		AssociationPair{
+			SessionType: gopenid.SessionDhSha1,
+			AssocType:   gopenid.AssocHmacSha1,
+		},
+	)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	res := &openIDResponse{
+		message: gopenid.NewMessage(gopenid.NsOpenID20),
+	}
+	if !assert.Nil(t, p.signer.Sign(res, "", []string{})) {
+		t.FailNow()
+	}
+
+	handle, _ := res.GetArg(gopenid.NewMessageKey(gopenid.NsOpenID20, "assoc_handle"))
+	if assoc, ok := p.store.GetAssociation(handle.String(), true); assert.True(t, ok) {
+		assocType := assoc.GetAssocType()
+		assert.Equal(t, assocType.Name(), gopenid.AssocHmacSha1.Name())
+	}
+
+	// rejected pairs keep the previous configuration
+	err = p.SetAllowedAssociations(
+		AssociationPair{
+			SessionType: gopenid.SessionDhSha1,
+			AssocType:   gopenid.AssocHmacSha256,
+		},
+	)
+	assert.Equal(t, err, ErrInvalidAssociationPair)
+	assert.True(t, p.assocPairs.isAllowed(gopenid.SessionDhSha1, gopenid.AssocHmacSha1))
+	assert.True(t, p.signer.assocPairs.isAllowed(gopenid.SessionDhSha1, gopenid.AssocHmacSha1))
+
+	unregisteredAssoc := gopenid.NewAssocType("HMAC-UNREGISTERED", sha256.New, sha256.Size)
+	err = p.SetAllowedAssociations(
+		AssociationPair{
+			SessionType: gopenid.NewSessionType("unregistered", unregisteredAssoc),
+			AssocType:   unregisteredAssoc,
+		},
+	)
+	if assert.Nil(t, err) {
+		res := &openIDResponse{
+			message: gopenid.NewMessage(gopenid.NsOpenID20),
+		}
+		assert.Equal(t, p.signer.Sign(res, "", []string{}), ErrNoAvailableAssociation)
+	}
+}
+
+func TestAssociateSessionRejected(t *testing.T) {
+	p := NewProvider(endpoint, newMemoryStore(), time.Hour, rand.Reader)
+	err := p.SetAllowedAssociations(
+		AssociationPair{
+			SessionType: gopenid.SessionNoEncryption,
+			AssocType:   gopenid.AssocHmacSha256,
+		},
+	)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	msg, err := gopenid.MessageFromQuery(url.Values{
+		"openid.ns":           []string{gopenid.NsOpenID20.String()},
+		"openid.mode":         []string{"associate"},
+		"openid.assoc_type":   []string{"HMAC-SHA1"},
+		"openid.session_type": []string{"no-encryption"},
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	session, err := p.EstablishSession("POST", msg)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	res, err := session.GetResponse()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	message := res.(*openIDResponse).GetMessage()
+	for key, expected := range map[string]gopenid.MessageValue{
+		"error":        gopenid.MessageValue(ErrAssociationPairRejected.Error()),
+		"error_code":   "unsupported-type",
+		"session_type": "no-encryption",
+		"assoc_type":   "HMAC-SHA256",
+	} {
+		actual, _ := message.GetArg(gopenid.NewMessageKey(gopenid.NsOpenID20, key))
+		assert.Equal(t, actual, expected, key)
+	}
+}
+
+func TestAssociateSessionTransport(t *testing.T) {
+	msg, err := gopenid.MessageFromQuery(url.Values{
+		"openid.ns":           []string{gopenid.NsOpenID20.String()},
+		"openid.mode":         []string{"associate"},
+		"openid.assoc_type":   []string{"HMAC-SHA1"},
+		"openid.session_type": []string{"no-encryption"},
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	for _, testCase := range []struct {
+		secure      bool
+		allowed     bool
+		sessionType gopenid.MessageValue
+		assocType   gopenid.MessageValue
+		rejected    bool
+	}{
+		{secure: false, allowed: false, sessionType: "DH-SHA1", assocType: "HMAC-SHA1", rejected: true},
+		{secure: false, allowed: true, sessionType: "no-encryption", assocType: "HMAC-SHA1"},
+		{secure: true, allowed: false, sessionType: "no-encryption", assocType: "HMAC-SHA1"},
+	} {
+		now := time.Now()
+		p, err := New(endpoint, newMemoryStore(),
+			WithInsecureNoEncryption(testCase.allowed),
+			WithAssociationLifetime(time.Hour),
+			WithClock(func() time.Time { return now }),
+		)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		session, err := p.EstablishSessionWithTransport("POST", msg, Transport{Secure: testCase.secure})
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		res, err := session.GetResponse()
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		message := res.(*openIDResponse).GetMessage()
+		get := func(key string) gopenid.MessageValue {
+			value, _ := message.GetArg(gopenid.NewMessageKey(gopenid.NsOpenID20, key))
+			return value
+		}
+
+		assert.Equal(t, get("session_type"), testCase.sessionType)
+		assert.Equal(t, get("assoc_type"), testCase.assocType)
+		if testCase.rejected {
+			assert.Equal(t, get("error_code").String(), "unsupported-type")
+			assert.Equal(t, get("error").String(), ErrInsecureNoEncryption.Error())
+			assert.Equal(t, get("mac_key").String(), "")
+			assert.Equal(t, res.StatusCode(), http.StatusBadRequest)
+		} else {
+			assert.NotEqual(t, get("mac_key").String(), "")
+			assert.Equal(t, get("expires_in").String(), "3600")
+			assert.Equal(t, res.StatusCode(), http.StatusOK)
+		}
+
+		w := httptest.NewRecorder()
+		res.WriteTo(w, httptest.NewRequest("POST", endpoint, nil))
+		assert.Equal(t, w.Code, res.StatusCode())
+		assert.Equal(t, w.Body.Bytes(), res.GetBody())
+		if testCase.rejected {
+			assert.Equal(t, w.Header().Get("Cache-Control"), "")
+		} else {
+			assert.Equal(t, w.Header().Get("Cache-Control"), "no-store")
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package provider
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+var (
+	ErrRedirectNotSet = errors.New("redirect URL not set")
+)
+
+// Decision is an outcome of Authenticator and ConsentDecider.
+type Decision int
+
+const (
+	// DecisionReject means the user is not logged in, or declined the relying party.
+	DecisionReject Decision = iota
+	// DecisionAccept means the user is logged in, or approved the relying party.
+	DecisionAccept
+	// DecisionInteract means the user must interact with the OP, e.g. log in or answer a consent page.
+	DecisionInteract
+)
+
+// AuthResult is a result of Authenticator and ConsentDecider.
+type AuthResult struct {
+	Decision Decision
+	// Identity is the OP-local identifier of the user. It is set by Authenticator on DecisionAccept.
+	Identity string
+	// ClaimedID is the claimed identifier to assert. Identity is asserted if it is empty.
+	ClaimedID string
+	// RedirectTo is the URL the user agent is sent to on DecisionInteract.
+	RedirectTo string
+
+	// UserID identifies the user in TrustStore. Identity is used if it is empty.
+	UserID string
+	// Attributes limit extension arguments sent in the assertion to the listed keys by extension namespace,
+	// if set by ConsentDecider. Arguments of all extensions are sent if it is nil.
+	Attributes map[gopenid.NamespaceURI][]string
+	// TrustFor makes the provider remember the approval of ConsentDecider in TrustStore for the duration,
+	// if positive.
+	TrustFor time.Duration
+}
+
+// AcceptResult returns AuthResult accepting identity and claimedID.
+func AcceptResult(identity, claimedID string) AuthResult {
+	return AuthResult{
+		Decision:  DecisionAccept,
+		Identity:  identity,
+		ClaimedID: claimedID,
+	}
+}
+
+// RejectResult returns AuthResult rejecting the request.
+func RejectResult() AuthResult {
+	return AuthResult{
+		Decision: DecisionReject,
+	}
+}
+
+// InteractResult returns AuthResult sending the user agent to redirectTo.
+func InteractResult(redirectTo string) AuthResult {
+	return AuthResult{
+		Decision:   DecisionInteract,
+		RedirectTo: redirectTo,
+	}
+}
+
+// AuthRequest is a checkid request given to Authenticator and ConsentDecider.
+type AuthRequest struct {
+	// HTTPRequest is the HTTP request which carried the checkid request.
+	HTTPRequest *http.Request
+	// Session is the session of the checkid request.
+	Session *CheckIDSession
+
+	Realm     Realm
+	ReturnTo  string
+	Identity  string
+	ClaimedID string
+	// Immediate is true for checkid_immediate, which must be answered without interacting with the user.
+	Immediate bool
+	// IdentifierSelect is true if the relying party let the OP choose the identifier.
+	IdentifierSelect bool
+	// Extensions are arguments of requested extensions by their namespace.
+	Extensions map[gopenid.NamespaceURI]map[string]string
+}
+
+func newAuthRequest(r *http.Request, s *CheckIDSession) *AuthRequest {
+	req := s.request
+	return &AuthRequest{
+		HTTPRequest: r,
+		Session:     s,
+
+		Realm:            req.Realm(),
+		ReturnTo:         req.ReturnTo(),
+		Identity:         req.Identity(),
+		ClaimedID:        req.ClaimedID(),
+		Immediate:        req.IsImmediate(),
+		IdentifierSelect: req.IsIdentifierSelect(),
+		Extensions:       req.Extensions(),
+	}
+}
+
+// Authenticator tells who is logged in to the OP.
+type Authenticator interface {
+	Authenticate(req *AuthRequest) (AuthResult, error)
+}
+
+// AuthenticatorFunc is an adapter to use ordinary functions as Authenticator.
+type AuthenticatorFunc func(req *AuthRequest) (AuthResult, error)
+
+// Authenticate calls f(req).
+func (f AuthenticatorFunc) Authenticate(req *AuthRequest) (AuthResult, error) {
+	return f(req)
+}
+
+// ConsentDecider tells whether the user approves sending an assertion to the relying party.
+type ConsentDecider interface {
+	DecideConsent(req *AuthRequest, identity string) (AuthResult, error)
+}
+
+// ConsentDeciderFunc is an adapter to use ordinary functions as ConsentDecider.
+type ConsentDeciderFunc func(req *AuthRequest, identity string) (AuthResult, error)
+
+// DecideConsent calls f(req, identity).
+func (f ConsentDeciderFunc) DecideConsent(req *AuthRequest, identity string) (AuthResult, error) {
+	return f(req, identity)
+}
+
+// Authorize consults Authenticator and ConsentDecider of p about s, and returns the response to send.
+//
+// The response is a positive assertion if both accepted, a redirect to the page they asked for
+// if the user must interact with the OP, and a negative assertion otherwise, including when the
+// accepted identity does not match the requested one. Requests in immediate mode are never
+// redirected, and result in setup_needed instead.
+//
+// If p has a TrustStore, ConsentDecider is not consulted for realms the user already trusts,
+// and approvals it asked to remember are stored.
+func (p *Provider) Authorize(r *http.Request, s *CheckIDSession) (Response, error) {
+	req := newAuthRequest(r, s)
+
+	result := RejectResult()
+	if p.authenticator != nil {
+		var err error
+		if result, err = p.authenticator.Authenticate(req); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Decision == DecisionAccept {
+		var err error
+		if result, err = p.decideConsent(req, result); err != nil {
+			return nil, err
+		}
+	}
+
+	switch result.Decision {
+	case DecisionAccept:
+		result = p.directIdentity(req, result)
+		s.Accept(result.Identity, result.ClaimedID)
+		s.SetAttributes(result.Attributes)
+	case DecisionInteract:
+		if !req.Immediate {
+			if result.RedirectTo == "" {
+				return nil, ErrRedirectNotSet
+			}
+			return newRedirectResponse(result.RedirectTo), nil
+		}
+		s.Reject()
+	default:
+		s.Reject()
+	}
+
+	res, err := s.GetResponse()
+	if isAuthorizationFailure(err) {
+		// the accepted user cannot assert the requested identifier, which is not an error of the request
+		p.logf("asserting identity failed: %v", err)
+		s.Reject()
+		return s.GetResponse()
+	} else if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// isAuthorizationFailure reports whether err tells that the accepted identity cannot be asserted.
+func isAuthorizationFailure(err error) bool {
+	switch err {
+	case ErrIdentityNotSet, ErrIdentitySet, ErrIdentityNotMatched, ErrDelegationNotVerified:
+		return true
+	default:
+		return false
+	}
+}
+
+// directIdentity replaces the identity in result with the pairwise identifier for the realm of req,
+// if p has DirectedIdentity and req lets the OP choose the identifier or asks for the pairwise identifier.
+func (p *Provider) directIdentity(req *AuthRequest, result AuthResult) AuthResult {
+	if p.directedIdentity == nil {
+		return result
+	}
+
+	userID := result.UserID
+	if userID == "" {
+		userID = result.Identity
+	}
+
+	directed := p.directedIdentity.Identifier(userID, req.Realm)
+	if req.IdentifierSelect || sameIdentifier(req.Identity, directed) {
+		result.Identity, result.ClaimedID = directed, ""
+	}
+
+	return result
+}
+
+// decideConsent decides whether the user authenticated as authenticated approves req,
+// by the trust decisions of the user and ConsentDecider.
+func (p *Provider) decideConsent(req *AuthRequest, authenticated AuthResult) (result AuthResult, err error) {
+	userID := authenticated.UserID
+	if userID == "" {
+		userID = authenticated.Identity
+	}
+
+	if p.trustStore != nil {
+		var d *TrustDecision
+		d, err = p.trustStore.GetTrust(userID, req.Realm)
+		if err == nil && !d.IsExpired(p.now()) && d.Covers(req, p.extensions) {
+			result = authenticated
+			result.Attributes = d.Attributes
+			return
+		} else if err != nil && err != ErrTrustNotFound {
+			return
+		}
+		err = nil
+	}
+
+	if p.consentDecider == nil {
+		result = authenticated
+		return
+	}
+
+	if result, err = p.consentDecider.DecideConsent(req, authenticated.Identity); err != nil || result.Decision != DecisionAccept {
+		return
+	}
+
+	attributes, trustFor := result.Attributes, result.TrustFor
+	result = authenticated
+	result.Attributes = attributes
+
+	if p.trustStore != nil && trustFor > 0 {
+		now := p.now()
+		err = p.trustStore.PutTrust(&TrustDecision{
+			UserID:     userID,
+			Realm:      req.Realm,
+			Identity:   result.Identity,
+			ClaimedID:  result.ClaimedID,
+			Attributes: attributes,
+			Created:    now,
+			Expires:    now.Add(trustFor),
+		})
+	}
+
+	return
+}
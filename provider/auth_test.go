@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+func establishCheckIDSession(t *testing.T, p *Provider, query url.Values) *CheckIDSession {
+	msg, err := gopenid.MessageFromQuery(query)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	session, err := p.EstablishSession("GET", msg)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	return session.(*CheckIDSession)
+}
+
+func TestAuthorize(t *testing.T) {
+	var (
+		authResult    AuthResult
+		consentResult AuthResult
+		authRequest   *AuthRequest
+	)
+
+	p, err := New(endpoint, newMemoryStore(),
+		WithAuthenticator(AuthenticatorFunc(func(req *AuthRequest) (AuthResult, error) {
+			authRequest = req
+			return authResult, nil
+		})),
+		WithConsentDecider(ConsentDeciderFunc(func(req *AuthRequest, identity string) (AuthResult, error) {
+			return consentResult, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	r, _ := http.NewRequest("GET", endpoint, nil)
+
+	for _, testCase := range []struct {
+		ns            gopenid.NamespaceURI
+		mode          string
+		authResult    AuthResult
+		consentResult AuthResult
+
+		redirectTo string
+		resMode    string
+		setupURL   bool
+	}{
+		{gopenid.NsOpenID20, "checkid_setup", InteractResult("http://example.com/login"), RejectResult(), "http://example.com/login", "", false},
+		{gopenid.NsOpenID20, "checkid_setup", RejectResult(), RejectResult(), "", "cancel", false},
+		{gopenid.NsOpenID20, "checkid_setup", AcceptResult("http://example.com/alice", ""), RejectResult(), "", "cancel", false},
+		{gopenid.NsOpenID20, "checkid_setup", AcceptResult("http://example.com/alice", ""), InteractResult("http://example.com/consent"), "http://example.com/consent", "", false},
+		{gopenid.NsOpenID20, "checkid_setup", AcceptResult("http://example.com/alice", ""), AcceptResult("", ""), "", "id_res", false},
+		{gopenid.NsOpenID20, "checkid_immediate", InteractResult("http://example.com/login"), RejectResult(), "", "setup_needed", true},
+		{gopenid.NsOpenID20, "checkid_immediate", AcceptResult("http://example.com/alice", ""), RejectResult(), "", "setup_needed", true},
+		{gopenid.NsOpenID20, "checkid_immediate", AcceptResult("http://example.com/alice", ""), AcceptResult("", ""), "", "id_res", false},
+		{gopenid.NsOpenID11, "checkid_immediate", InteractResult("http://example.com/login"), RejectResult(), "", "id_res", true},
+	} {
+		authResult = testCase.authResult
+		consentResult = testCase.consentResult
+
+		query := url.Values{
+			"openid.mode":       []string{testCase.mode},
+			"openid.identity":   []string{gopenid.NsIdentifierSelect.String()},
+			"openid.claimed_id": []string{gopenid.NsIdentifierSelect.String()},
+			"openid.return_to":  []string{"http://rp.example.com/return"},
+		}
+		if testCase.ns == gopenid.NsOpenID20 {
+			query.Set("openid.ns", testCase.ns.String())
+		}
+
+		s := establishCheckIDSession(t, p, query)
+		res, err := p.Authorize(r, s)
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		if !assert.True(t, res.NeedsRedirect()) {
+			continue
+		}
+
+		if testCase.redirectTo != "" {
+			assert.Equal(t, res.GetRedirectTo(), testCase.redirectTo)
+			continue
+		}
+
+		location, _ := url.Parse(res.GetRedirectTo())
+		assert.Equal(t, location.Query().Get("openid.mode"), testCase.resMode, "%+v", testCase)
+		assert.Equal(t, location.Query().Get("openid.user_setup_url") != "", testCase.setupURL, "%+v", testCase)
+	}
+
+	assert.Equal(t, authRequest.ReturnTo, "http://rp.example.com/return")
+	assert.Equal(t, authRequest.Realm.Host, "rp.example.com")
+	assert.True(t, authRequest.Immediate)
+	assert.True(t, authRequest.IdentifierSelect)
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	errAuthenticator := errors.New("authenticator is down")
+
+	p, err := New(endpoint, newMemoryStore(),
+		WithAuthenticator(AuthenticatorFunc(func(req *AuthRequest) (AuthResult, error) {
+			if req.Extensions["http://example.com/ext"]["fail"] == "true" {
+				return AuthResult{}, errAuthenticator
+			}
+			return InteractResult(""), nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	r, _ := http.NewRequest("GET", endpoint, nil)
+	query := url.Values{
+		"openid.ns":        []string{gopenid.NsOpenID20.String()},
+		"openid.mode":      []string{"checkid_setup"},
+		"openid.return_to": []string{"http://rp.example.com/return"},
+	}
+
+	_, err = p.Authorize(r, establishCheckIDSession(t, p, query))
+	assert.Equal(t, err, ErrRedirectNotSet)
+
+	query.Set("openid.ns.ext", "http://example.com/ext")
+	query.Set("openid.ext.fail", "true")
+	_, err = p.Authorize(r, establishCheckIDSession(t, p, query))
+	assert.Equal(t, err, errAuthenticator)
+}
+
+func TestAuthorizeIdentityNotMatched(t *testing.T) {
+	p, err := New(endpoint, newMemoryStore(),
+		WithAuthenticator(AuthenticatorFunc(func(req *AuthRequest) (AuthResult, error) {
+			return AcceptResult("http://example.com/bob", ""), nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	r, _ := http.NewRequest("GET", endpoint, nil)
+	for mode, resMode := range map[string]string{
+		"checkid_setup":     "cancel",
+		"checkid_immediate": "setup_needed",
+	} {
+		res, err := p.Authorize(r, establishCheckIDSession(t, p, url.Values{
+			"openid.ns":         []string{gopenid.NsOpenID20.String()},
+			"openid.mode":       []string{mode},
+			"openid.identity":   []string{"http://example.com/alice"},
+			"openid.claimed_id": []string{"http://example.com/alice"},
+			"openid.return_to":  []string{"http://rp.example.com/return"},
+		}))
+		if !assert.Nil(t, err) || !assert.True(t, res.NeedsRedirect()) {
+			continue
+		}
+
+		location, _ := url.Parse(res.GetRedirectTo())
+		assert.Equal(t, location.Query().Get("openid.mode"), resMode, mode)
+		assert.Equal(t, location.Query().Get("openid.identity"), "", mode)
+	}
+}
@@ -0,0 +1,316 @@
+package provider
+
+import (
+	"crypto/rand"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+const (
+	DefaultNonceWindow      = 5 * time.Minute
+	DefaultMaxDHModulusBits = 4096
+	DefaultStateLifetime    = 30 * time.Minute
+)
+
+var (
+	ErrInvalidEndpoint           = errors.New("endpoint must be an absolute http or https URL")
+	ErrStoreNotSet               = errors.New("store not set")
+	ErrSecretGeneratorNotSet     = errors.New("secret generator not set")
+	ErrClockNotSet               = errors.New("clock not set")
+	ErrInvalidLifetime           = errors.New("association lifetime must be positive")
+	ErrInvalidNonceWindow        = errors.New("nonce window must be positive")
+	ErrInvalidStateLifetime      = errors.New("state lifetime must be positive")
+	ErrInvalidExtensionAlias     = errors.New("invalid extension alias")
+	ErrDuplicateExtensionHandler = errors.New("duplicate extension handler")
+	ErrInvalidMessageLimits      = errors.New("message limits must not be negative")
+)
+
+// Config is a set of settings of Provider.
+//
+// A Config should be obtained with NewConfig and then modified. Zero values are taken
+// as they are, so Validate rejects a literal Config which omits required settings.
+type Config struct {
+	// Endpoint is the URL of the OP Endpoint.
+	Endpoint string
+	// Store persists associations and nonces.
+	Store gopenid.Store
+
+	// AssociationLifetime is the lifetime of associations established by associate requests.
+	AssociationLifetime time.Duration
+	// StatelessLifetime is the lifetime of private associations used in stateless mode.
+	StatelessLifetime time.Duration
+	// SecretGenerator is the source of MAC keys, association handles and nonces.
+	SecretGenerator io.Reader
+	// AllowedAssociations is the list of allowed combinations of session type and association type,
+	// ordered by preference.
+	AllowedAssociations []AssociationPair
+	// Keyring protects association secrets at rest, and seals state tokens of SuspendCheckID, if set.
+	Keyring *gopenid.Keyring
+	// StateLifetime is how long state tokens of SuspendCheckID are accepted.
+	StateLifetime time.Duration
+	// MACSigner computes signatures, if set. In-process HMAC is used otherwise.
+	MACSigner gopenid.MACSigner
+	// AllowInsecureNoEncryption allows no-encryption sessions over plain HTTP.
+	// OpenID 2.0 forbids them, so this must be used only for development.
+	AllowInsecureNoEncryption bool
+
+	// MessageLimits limits sizes of OpenID messages Handler parses. Zero fields are not limited.
+	MessageLimits gopenid.MessageLimits
+	// MaxDHModulusBits is the maximum size of dh_modulus in associate requests, which also bounds
+	// dh_gen and dh_consumer_public. It is not limited if zero.
+	MaxDHModulusBits int
+
+	// NonceWindow is how long a response nonce is accepted by check_authentication after it was issued.
+	NonceWindow time.Duration
+	// Clock returns the current time.
+	Clock func() time.Time
+	// Logger receives errors which cannot be reported to relying parties, if set.
+	Logger *log.Logger
+
+	// RealmPolicy decides whether the realm of checkid requests is acceptable, if set.
+	// Wildcards over public suffixes are rejected regardless of it.
+	RealmPolicy RealmPolicy
+	// Extensions add extension arguments to positive assertions.
+	Extensions []ExtensionHandler
+
+	// Authenticator tells who is logged in. All checkid requests are rejected if it is not set.
+	Authenticator Authenticator
+	// ConsentDecider tells whether the user approves relying parties. All of them are approved if it is not set.
+	ConsentDecider ConsentDecider
+	// TrustStore remembers realms users trust, if set.
+	TrustStore TrustStore
+	// DirectedIdentity issues pairwise identifiers for identifier_select requests, if set.
+	DirectedIdentity *DirectedIdentity
+	// IdentityResolver maps identifiers in checkid requests to accounts and verifies delegation, if set.
+	IdentityResolver IdentityResolver
+
+	// IdentityTemplate renders identity pages for HTML-based discovery with IdentityPage.
+	// DefaultIdentityTemplate is used if it is nil.
+	IdentityTemplate *template.Template
+	// ProviderTemplate renders the OP Identifier page for user agents with ProviderPage.
+	// DefaultProviderTemplate is used if it is nil.
+	ProviderTemplate *template.Template
+}
+
+// NewConfig returns a new Config with default settings.
+func NewConfig(endpoint string, store gopenid.Store) *Config {
+	return &Config{
+		Endpoint: endpoint,
+		Store:    store,
+
+		AssociationLifetime: gopenid.AssociationLifetime,
+		StatelessLifetime:   gopenid.AssociationLifetime,
+		SecretGenerator:     rand.Reader,
+		AllowedAssociations: DefaultAssociationPairs,
+		StateLifetime:       DefaultStateLifetime,
+
+		MessageLimits:    gopenid.DefaultMessageLimits,
+		MaxDHModulusBits: DefaultMaxDHModulusBits,
+
+		NonceWindow: DefaultNonceWindow,
+		Clock:       time.Now,
+
+		IdentityTemplate: DefaultIdentityTemplate,
+		ProviderTemplate: DefaultProviderTemplate,
+	}
+}
+
+// Validate returns an error if config is not usable.
+func (config *Config) Validate() error {
+	if parsed, err := url.Parse(config.Endpoint); err != nil || !parsed.IsAbs() || parsed.Host == "" {
+		return ErrInvalidEndpoint
+	} else if !(parsed.Scheme == "http" || parsed.Scheme == "https") {
+		return ErrInvalidEndpoint
+	}
+
+	if config.Store == nil {
+		return ErrStoreNotSet
+	} else if config.SecretGenerator == nil {
+		return ErrSecretGeneratorNotSet
+	} else if config.Clock == nil {
+		return ErrClockNotSet
+	}
+
+	if config.AssociationLifetime <= 0 || config.StatelessLifetime <= 0 {
+		return ErrInvalidLifetime
+	} else if config.NonceWindow <= 0 {
+		return ErrInvalidNonceWindow
+	} else if config.StateLifetime <= 0 {
+		return ErrInvalidStateLifetime
+	}
+
+	if limits := config.MessageLimits; limits.MaxArgs < 0 || limits.MaxKeyLength < 0 || limits.MaxValueLength < 0 || limits.MaxAliases < 0 {
+		return ErrInvalidMessageLimits
+	} else if config.MaxDHModulusBits < 0 {
+		return ErrInvalidMessageLimits
+	}
+
+	if _, err := newAssociationPairs(config.AllowedAssociations); err != nil {
+		return err
+	}
+
+	return validateExtensionHandlers(config.Extensions)
+}
+
+// Option modifies Config given to New.
+type Option func(*Config)
+
+// WithAssociationLifetime sets Config.AssociationLifetime.
+func WithAssociationLifetime(lifetime time.Duration) Option {
+	return func(config *Config) {
+		config.AssociationLifetime = lifetime
+	}
+}
+
+// WithStatelessLifetime sets Config.StatelessLifetime.
+func WithStatelessLifetime(lifetime time.Duration) Option {
+	return func(config *Config) {
+		config.StatelessLifetime = lifetime
+	}
+}
+
+// WithSecretGenerator sets Config.SecretGenerator.
+func WithSecretGenerator(secretGenerator io.Reader) Option {
+	return func(config *Config) {
+		config.SecretGenerator = secretGenerator
+	}
+}
+
+// WithStateLifetime sets Config.StateLifetime.
+func WithStateLifetime(lifetime time.Duration) Option {
+	return func(config *Config) {
+		config.StateLifetime = lifetime
+	}
+}
+
+// WithAllowedAssociations sets Config.AllowedAssociations.
+func WithAllowedAssociations(pairs ...AssociationPair) Option {
+	return func(config *Config) {
+		config.AllowedAssociations = pairs
+	}
+}
+
+// WithKeyring sets Config.Keyring.
+func WithKeyring(keyring *gopenid.Keyring) Option {
+	return func(config *Config) {
+		config.Keyring = keyring
+	}
+}
+
+// WithMACSigner sets Config.MACSigner.
+func WithMACSigner(macSigner gopenid.MACSigner) Option {
+	return func(config *Config) {
+		config.MACSigner = macSigner
+	}
+}
+
+// WithInsecureNoEncryption sets Config.AllowInsecureNoEncryption.
+func WithInsecureNoEncryption(allow bool) Option {
+	return func(config *Config) {
+		config.AllowInsecureNoEncryption = allow
+	}
+}
+
+// WithMessageLimits sets Config.MessageLimits.
+func WithMessageLimits(limits gopenid.MessageLimits) Option {
+	return func(config *Config) {
+		config.MessageLimits = limits
+	}
+}
+
+// WithMaxDHModulusBits sets Config.MaxDHModulusBits.
+func WithMaxDHModulusBits(bits int) Option {
+	return func(config *Config) {
+		config.MaxDHModulusBits = bits
+	}
+}
+
+// WithNonceWindow sets Config.NonceWindow.
+func WithNonceWindow(window time.Duration) Option {
+	return func(config *Config) {
+		config.NonceWindow = window
+	}
+}
+
+// WithClock sets Config.Clock.
+func WithClock(clock func() time.Time) Option {
+	return func(config *Config) {
+		config.Clock = clock
+	}
+}
+
+// WithLogger sets Config.Logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(config *Config) {
+		config.Logger = logger
+	}
+}
+
+// WithRealmPolicy sets Config.RealmPolicy.
+func WithRealmPolicy(policy RealmPolicy) Option {
+	return func(config *Config) {
+		config.RealmPolicy = policy
+	}
+}
+
+// WithExtensions appends handlers to Config.Extensions.
+func WithExtensions(handlers ...ExtensionHandler) Option {
+	return func(config *Config) {
+		config.Extensions = append(config.Extensions, handlers...)
+	}
+}
+
+// WithAuthenticator sets Config.Authenticator.
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(config *Config) {
+		config.Authenticator = authenticator
+	}
+}
+
+// WithConsentDecider sets Config.ConsentDecider.
+func WithConsentDecider(decider ConsentDecider) Option {
+	return func(config *Config) {
+		config.ConsentDecider = decider
+	}
+}
+
+// WithTrustStore sets Config.TrustStore.
+func WithTrustStore(store TrustStore) Option {
+	return func(config *Config) {
+		config.TrustStore = store
+	}
+}
+
+// WithDirectedIdentity sets Config.DirectedIdentity.
+func WithDirectedIdentity(directed *DirectedIdentity) Option {
+	return func(config *Config) {
+		config.DirectedIdentity = directed
+	}
+}
+
+// WithIdentityResolver sets Config.IdentityResolver.
+func WithIdentityResolver(resolver IdentityResolver) Option {
+	return func(config *Config) {
+		config.IdentityResolver = resolver
+	}
+}
+
+// WithIdentityTemplate sets Config.IdentityTemplate.
+func WithIdentityTemplate(tmpl *template.Template) Option {
+	return func(config *Config) {
+		config.IdentityTemplate = tmpl
+	}
+}
+
+// WithProviderTemplate sets Config.ProviderTemplate.
+func WithProviderTemplate(tmpl *template.Template) Option {
+	return func(config *Config) {
+		config.ProviderTemplate = tmpl
+	}
+}
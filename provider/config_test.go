@@ -0,0 +1,180 @@
+package provider
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+type testExtension struct {
+	alias string
+}
+
+func (ext *testExtension) GetNamespace() gopenid.NamespaceURI {
+	return "http://example.com/ext/" + gopenid.NamespaceURI(ext.alias)
+}
+
+func (ext *testExtension) GetAlias() string {
+	return ext.alias
+}
+
+func (ext *testExtension) Respond(req Request, identity string) (map[string]gopenid.MessageValue, error) {
+	return map[string]gopenid.MessageValue{
+		"identity": gopenid.MessageValue(identity),
+	}, nil
+}
+
+func TestConfigValidate(t *testing.T) {
+	store := newMemoryStore()
+
+	_, err := New("", store)
+	assert.Equal(t, err, ErrInvalidEndpoint)
+	_, err = New("ftp://example.com/", store)
+	assert.Equal(t, err, ErrInvalidEndpoint)
+	_, err = New(endpoint, nil)
+	assert.Equal(t, err, ErrStoreNotSet)
+	_, err = New(endpoint, store, WithSecretGenerator(nil))
+	assert.Equal(t, err, ErrSecretGeneratorNotSet)
+	_, err = New(endpoint, store, WithClock(nil))
+	assert.Equal(t, err, ErrClockNotSet)
+	_, err = New(endpoint, store, WithAssociationLifetime(0))
+	assert.Equal(t, err, ErrInvalidLifetime)
+	_, err = New(endpoint, store, WithStatelessLifetime(-time.Second))
+	assert.Equal(t, err, ErrInvalidLifetime)
+	_, err = New(endpoint, store, WithNonceWindow(0))
+	assert.Equal(t, err, ErrInvalidNonceWindow)
+	_, err = New(endpoint, store, WithStateLifetime(0))
+	assert.Equal(t, err, ErrInvalidStateLifetime)
+	_, err = New(endpoint, store, WithMessageLimits(gopenid.MessageLimits{MaxArgs: -1}))
+	assert.Equal(t, err, ErrInvalidMessageLimits)
+	_, err = New(endpoint, store, WithMaxDHModulusBits(-1))
+	assert.Equal(t, err, ErrInvalidMessageLimits)
+	_, err = New(endpoint, store, WithAllowedAssociations())
+	assert.Equal(t, err, ErrNoAssociationPairs)
+	_, err = New(endpoint, store, WithExtensions(&testExtension{alias: "mode"}))
+	assert.Equal(t, err, ErrInvalidExtensionAlias)
+	_, err = New(endpoint, store, WithExtensions(&testExtension{alias: "ext"}, &testExtension{alias: "ext"}))
+	assert.Equal(t, err, ErrDuplicateExtensionHandler)
+
+	p, err := New(endpoint, store, WithExtensions(&testExtension{alias: "ext"}))
+	if assert.Nil(t, err) {
+		assert.Len(t, p.extensions, 1)
+	}
+
+	// the compatibility constructor never validates, as before Config existed
+	assert.NotPanics(t, func() {
+		NewProvider("", store, time.Hour, rand.Reader)
+		NewProvider("/relative", nil, 0, rand.Reader)
+	})
+
+	p = NewProvider(endpoint, store, time.Hour, nil)
+	assert.Equal(t, p.signer.secretGenerator, rand.Reader)
+}
+
+func TestProviderRealmPolicy(t *testing.T) {
+	errRejected := errors.New("rejected")
+
+	p, err := New(endpoint, newMemoryStore(), WithRealmPolicy(RealmPolicyFunc(func(realm Realm) error {
+		if realm.Host == "evil.example.com" {
+			return errRejected
+		}
+		return nil
+	})))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	for realm, expected := range map[string]error{
+		"http://example.com/":      nil,
+		"http://evil.example.com/": errRejected,
+	} {
+		msg, err := gopenid.MessageFromQuery(url.Values{
+			"openid.ns":    []string{gopenid.NsOpenID20.String()},
+			"openid.mode":  []string{"checkid_setup"},
+			"openid.realm": []string{realm},
+		})
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		_, err = p.EstablishSession("GET", msg)
+		assert.Equal(t, err, expected)
+	}
+}
+
+func TestProviderNonceWindow(t *testing.T) {
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	p, err := New(endpoint, newMemoryStore(),
+		WithClock(func() time.Time { return now }),
+		WithNonceWindow(time.Minute),
+		WithExtensions(&testExtension{alias: "ext"}),
+	)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	msg, err := gopenid.MessageFromQuery(url.Values{
+		"openid.ns":         []string{gopenid.NsOpenID20.String()},
+		"openid.mode":       []string{"checkid_setup"},
+		"openid.identity":   []string{gopenid.NsIdentifierSelect.String()},
+		"openid.claimed_id": []string{gopenid.NsIdentifierSelect.String()},
+		"openid.return_to":  []string{"http://example.com/signin"},
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	session, err := p.EstablishSession("GET", msg)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	session.(*CheckIDSession).Accept("http://example.com/user", "")
+
+	res, err := session.GetResponse()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assertion := res.(*openIDResponse).GetMessage()
+	ns := assertion.GetOpenIDNamespace()
+	if signed, ok := assertion.GetArg(gopenid.NewMessageKey(ns, "signed")); assert.True(t, ok) {
+		assert.Equal(t, signed.String(), "op_endpoint,return_to,response_nonce,assoc_handle,claimed_id,identity,ns.ext,ext.identity")
+	}
+
+	query := assertion.ToQuery()
+	query.Set("openid.mode", "check_authentication")
+	verify, err := gopenid.MessageFromQuery(query)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	// replayed after the window
+	now = now.Add(2 * time.Minute)
+	session, err = p.EstablishSession("POST", verify)
+	if assert.Nil(t, err) {
+		_, err = session.GetResponse()
+		assert.Equal(t, err, ErrNonceExpired)
+	}
+
+	// verified once in the window
+	now = now.Add(-2 * time.Minute)
+	session, err = p.EstablishSession("POST", verify)
+	if assert.Nil(t, err) {
+		res, err := session.GetResponse()
+		if assert.Nil(t, err) {
+			isValid, _ := res.(*openIDResponse).GetArg(gopenid.NewMessageKey(ns, "is_valid"))
+			assert.Equal(t, isValid.String(), "true")
+		}
+	}
+
+	session, err = p.EstablishSession("POST", verify)
+	if assert.Nil(t, err) {
+		_, err = session.GetResponse()
+		assert.Equal(t, err, ErrKnownNonce)
+	}
+}
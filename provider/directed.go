@@ -0,0 +1,120 @@
+package provider
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrInvalidDirectedIdentityPrefix = errors.New("directed identity prefix must be an absolute http or https URL")
+	ErrInvalidDirectedIdentitySecret = errors.New("directed identity secret must be 32 bytes")
+	ErrInvalidDirectedIdentifier     = errors.New("invalid directed identifier")
+)
+
+// DirectedIdentity derives pairwise pseudonymous identifiers, which differ for every realm
+// but are stable for the same user and realm.
+//
+// An identifier is the user ID encrypted deterministically with a nonce derived from the
+// user ID and the realm, so the OP can map it back to the user without storing it, while
+// relying parties can neither learn the user ID nor link identifiers across realms.
+type DirectedIdentity struct {
+	prefix string
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// NewDirectedIdentity returns a DirectedIdentity issuing identifiers under prefix.
+// secret must be 32 random bytes kept unchanged, since changing it changes every identifier.
+func NewDirectedIdentity(prefix string, secret []byte) (*DirectedIdentity, error) {
+	if parsed, err := url.Parse(prefix); err != nil || !parsed.IsAbs() || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, ErrInvalidDirectedIdentityPrefix
+	}
+
+	if len(secret) != 32 {
+		return nil, ErrInvalidDirectedIdentitySecret
+	}
+
+	block, err := aes.NewCipher(deriveDirectedKey(secret, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirectedIdentity{
+		prefix: prefix,
+		aead:   aead,
+		macKey: deriveDirectedKey(secret, "nonce"),
+	}, nil
+}
+
+func deriveDirectedKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// GetPrefix returns the URL prefix of identifiers.
+func (d *DirectedIdentity) GetPrefix() string {
+	return d.prefix
+}
+
+// Identifier returns the identifier of userID for realm.
+// Realms differing only in wildcard, path or query share the identifier.
+func (d *DirectedIdentity) Identifier(userID string, realm Realm) string {
+	mac := hmac.New(sha256.New, d.macKey)
+	mac.Write([]byte(normalizeDirectedRealm(realm)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userID))
+	nonce := mac.Sum(nil)[:d.aead.NonceSize()]
+
+	sealed := d.aead.Seal(nonce, nonce, []byte(userID), nil)
+	return d.prefix + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// Resolve returns the user ID identifier was issued for.
+func (d *DirectedIdentity) Resolve(identifier string) (userID string, err error) {
+	if !strings.HasPrefix(identifier, d.prefix) {
+		err = ErrInvalidDirectedIdentifier
+		return
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(identifier[len(d.prefix):])
+	if err != nil || len(sealed) < d.aead.NonceSize() {
+		err = ErrInvalidDirectedIdentifier
+		return
+	}
+
+	plaintext, err := d.aead.Open(nil, sealed[:d.aead.NonceSize()], sealed[d.aead.NonceSize():], nil)
+	if err != nil {
+		err = ErrInvalidDirectedIdentifier
+		return
+	}
+
+	userID = string(plaintext)
+	return
+}
+
+// IsIdentifierOf reports whether identifier was issued for userID and realm.
+func (d *DirectedIdentity) IsIdentifierOf(identifier, userID string, realm Realm) bool {
+	return hmac.Equal([]byte(identifier), []byte(d.Identifier(userID, realm)))
+}
+
+// normalizeDirectedRealm returns the origin of realm, with its wildcard removed.
+func normalizeDirectedRealm(realm Realm) string {
+	host := strings.ToLower(strings.TrimPrefix(realm.Host, "."))
+	if realm.Port != "" {
+		host += ":" + realm.Port
+	}
+
+	return strings.ToLower(realm.Scheme) + "://" + host
+}
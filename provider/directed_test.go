@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+var directedSecret = bytes.Repeat([]byte{0x42}, 32)
+
+func TestDirectedIdentity(t *testing.T) {
+	_, err := NewDirectedIdentity("/id/", directedSecret)
+	assert.Equal(t, err, ErrInvalidDirectedIdentityPrefix)
+	_, err = NewDirectedIdentity("http://example.com/id/", directedSecret[:16])
+	assert.Equal(t, err, ErrInvalidDirectedIdentitySecret)
+
+	directed, err := NewDirectedIdentity("http://example.com/id/", directedSecret)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	parse := func(rawurl string) Realm {
+		realm, err := ParseRealm(rawurl)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		return realm
+	}
+
+	identifier := directed.Identifier("alice", parse("http://*.example.org/"))
+	assert.True(t, strings.HasPrefix(identifier, "http://example.com/id/"))
+	assert.False(t, strings.Contains(identifier, "alice"))
+
+	// stable, and collapsing wildcard, path and query
+	assert.Equal(t, directed.Identifier("alice", parse("http://*.example.org/")), identifier)
+	assert.Equal(t, directed.Identifier("alice", parse("http://example.org/path?query")), identifier)
+	assert.True(t, directed.IsIdentifierOf(identifier, "alice", parse("http://example.org/")))
+
+	// pairwise
+	assert.NotEqual(t, directed.Identifier("alice", parse("https://example.org/")), identifier)
+	assert.NotEqual(t, directed.Identifier("alice", parse("http://example.org:8080/")), identifier)
+	assert.NotEqual(t, directed.Identifier("alice", parse("http://www.example.org/")), identifier)
+	assert.NotEqual(t, directed.Identifier("bob", parse("http://example.org/")), identifier)
+	assert.False(t, directed.IsIdentifierOf(identifier, "bob", parse("http://example.org/")))
+
+	if userID, err := directed.Resolve(identifier); assert.Nil(t, err) {
+		assert.Equal(t, userID, "alice")
+	}
+
+	for _, invalid := range []string{
+		"http://example.com/alice",
+		"http://example.com/id/",
+		"http://example.com/id/!!",
+		identifier[:len(identifier)-1] + "A",
+	} {
+		_, err := directed.Resolve(invalid)
+		assert.Equal(t, err, ErrInvalidDirectedIdentifier, invalid)
+	}
+}
+
+func TestAuthorizeDirectedIdentity(t *testing.T) {
+	directed, err := NewDirectedIdentity("http://example.com/id/", directedSecret)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	h := newTestHandler(t,
+		WithDirectedIdentity(directed),
+		WithAuthenticator(AuthenticatorFunc(func(req *AuthRequest) (AuthResult, error) {
+			result := AcceptResult("http://example.com/users/alice", "")
+			result.UserID = "alice"
+			return result, nil
+		})),
+	)
+	assert.Equal(t, h.DirectedPrefix, "/id/")
+
+	checkID := func(identity, returnTo string) url.Values {
+		w := serveTestRequest(h, "GET", "/openid", url.Values{
+			"openid.ns":         []string{gopenid.NsOpenID20.String()},
+			"openid.mode":       []string{"checkid_setup"},
+			"openid.identity":   []string{identity},
+			"openid.claimed_id": []string{identity},
+			"openid.return_to":  []string{returnTo},
+		})
+		if !assert.Equal(t, w.Code, http.StatusFound) {
+			t.FailNow()
+		}
+
+		location, _ := url.Parse(w.Header().Get("Location"))
+		return location.Query()
+	}
+
+	query := checkID(gopenid.NsIdentifierSelect.String(), "http://rp.example.org/return")
+	identifier := query.Get("openid.claimed_id")
+	assert.Equal(t, query.Get("openid.mode"), "id_res")
+	assert.Equal(t, query.Get("openid.identity"), identifier)
+	if realm, err := ParseRealm("http://rp.example.org/"); assert.Nil(t, err) {
+		assert.Equal(t, identifier, directed.Identifier("alice", realm))
+	}
+
+	// the pairwise identifier is asserted when asked for again
+	query = checkID(identifier, "http://rp.example.org/return")
+	assert.Equal(t, query.Get("openid.mode"), "id_res")
+	assert.Equal(t, query.Get("openid.claimed_id"), identifier)
+
+	// but not to another realm
+	query = checkID(gopenid.NsIdentifierSelect.String(), "http://rp.example.net/return")
+	assert.NotEqual(t, query.Get("openid.claimed_id"), identifier)
+
+	// XRDS of pairwise identifiers
+	w := serveTestRequest(h, "GET", strings.TrimPrefix(identifier, "http://example.com"), nil)
+	if assert.Equal(t, w.Code, http.StatusOK) {
+		assert.Contains(t, w.Body.String(), "<LocalID>"+identifier+"</LocalID>")
+	}
+
+	w = serveTestRequest(h, "GET", "/id/unknown", nil)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"errors"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+// ProtocolError is an error reported to relying parties by an OpenID error response.
+//
+// Errors other than ProtocolError are reported as ProtocolError with only Err set.
+type ProtocolError struct {
+	// Err is sent as openid.error. If it is nil, ErrorCode, or "unknown error" without it, is sent instead.
+	Err error
+	// ErrorCode is sent as openid.error_code of direct error responses, if not empty.
+	ErrorCode string
+	// Contact is sent as openid.contact, if not empty.
+	Contact string
+	// Reference is sent as openid.reference, if not empty.
+	Reference string
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	} else if e.ErrorCode != "" {
+		return e.ErrorCode
+	}
+
+	return "unknown error"
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// asProtocolError returns err as *ProtocolError.
+func asProtocolError(err error) *ProtocolError {
+	var protocolErr *ProtocolError
+	if errors.As(err, &protocolErr) {
+		return protocolErr
+	}
+
+	return &ProtocolError{
+		Err: err,
+	}
+}
+
+// GetErrorResponse returns the error response reporting err about msg, which arrived by method.
+//
+// Indirect requests are answered by redirecting the user agent to return_to, only if the realm of
+// the request is valid, allowed by the realm policy and covers return_to, so that p never redirects
+// to URLs a relying party could not receive assertions at. Other requests are answered by a Key-Value
+// form body with status 400, which is shown to the user for indirect requests.
+func (p *Provider) GetErrorResponse(method string, msg *gopenid.Message, err error) Response {
+	protocolErr := asProtocolError(err)
+	indirect := isIndirectRequest(method, msg)
+
+	res := &openIDResponse{
+		message:     gopenid.NewMessage(msg.GetOpenIDNamespace()),
+		contentType: "text/plain;charset=utf8",
+		isError:     true,
+	}
+	res.AddArg(gopenid.NewMessageKey(res.GetNamespace(), "mode"), "error")
+	res.AddArg(gopenid.NewMessageKey(res.GetNamespace(), "error"), gopenid.MessageValue(protocolErr.Error()))
+	if protocolErr.ErrorCode != "" && !indirect {
+		res.AddArg(gopenid.NewMessageKey(res.GetNamespace(), "error_code"), gopenid.MessageValue(protocolErr.ErrorCode))
+	}
+	if protocolErr.Contact != "" {
+		res.AddArg(gopenid.NewMessageKey(res.GetNamespace(), "contact"), gopenid.MessageValue(protocolErr.Contact))
+	}
+	if protocolErr.Reference != "" {
+		res.AddArg(gopenid.NewMessageKey(res.GetNamespace(), "reference"), gopenid.MessageValue(protocolErr.Reference))
+	}
+
+	if indirect {
+		if returnTo, ok := p.errorReturnTo(msg); ok {
+			res.needsRedirect = true
+			res.returnTo = returnTo
+		}
+	}
+
+	return res
+}
+
+// errorReturnTo returns return_to of msg if an error response can be sent there, i.e. the realm of msg,
+// which defaults to return_to as for checkid requests, is valid, allowed by p and covers return_to.
+func (p *Provider) errorReturnTo(msg *gopenid.Message) (string, bool) {
+	ns := msg.GetOpenIDNamespace()
+
+	returnTo, _ := msg.GetArg(gopenid.NewMessageKey(ns, "return_to"))
+	if returnTo == "" {
+		return "", false
+	} else if _, err := ParseRealm(returnTo.String()); err != nil {
+		return "", false
+	}
+
+	realm, _ := msg.GetArg(gopenid.NewMessageKey(ns, "realm"))
+	if realm == "" {
+		realm = returnTo
+	}
+
+	parsedRealm, err := ParseRealm(realm.String())
+	if err != nil || !parsedRealm.Validate(returnTo.String()) {
+		return "", false
+	} else if checkRealm(parsedRealm, p.realmPolicy) != nil {
+		return "", false
+	}
+
+	return returnTo.String(), true
+}
+
+// isIndirectRequest reports whether msg was sent through the user agent.
+func isIndirectRequest(method string, msg *gopenid.Message) bool {
+	mode, _ := msg.GetArg(gopenid.NewMessageKey(msg.GetOpenIDNamespace(), "mode"))
+	switch mode {
+	case "checkid_immediate", "checkid_setup":
+		return true
+	case "associate", "check_authentication":
+		return false
+	default:
+		return method != "POST"
+	}
+}
@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetErrorResponse(t *testing.T) {
+	p, err := New(endpoint, newMemoryStore())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	protocolErr := &ProtocolError{
+		Err:       ErrInvalidCheckIDRequest,
+		ErrorCode: "invalid-request",
+		Contact:   "admin@example.com",
+		Reference: "ref-1",
+	}
+	assert.True(t, errors.Is(protocolErr, ErrInvalidCheckIDRequest))
+	assert.Equal(t, protocolErr.Error(), ErrInvalidCheckIDRequest.Error())
+
+	// direct request
+	msg, _ := gopenid.MessageFromQuery(url.Values{
+		"openid.ns":   []string{gopenid.NsOpenID20.String()},
+		"openid.mode": []string{"check_authentication"},
+	})
+	res := p.GetErrorResponse("POST", msg, protocolErr)
+	assert.False(t, res.NeedsRedirect())
+	assert.Equal(t, res.StatusCode(), http.StatusBadRequest)
+	for _, line := range []string{
+		"mode:error",
+		"error:" + ErrInvalidCheckIDRequest.Error(),
+		"error_code:invalid-request",
+		"contact:admin@example.com",
+		"reference:ref-1",
+	} {
+		assert.Contains(t, strings.Split(string(res.GetBody()), "\n"), line)
+	}
+
+	// indirect request with return_to
+	msg, _ = gopenid.MessageFromQuery(url.Values{
+		"openid.ns":        []string{gopenid.NsOpenID20.String()},
+		"openid.mode":      []string{"checkid_setup"},
+		"openid.return_to": []string{"http://rp.example.com/return?session=1"},
+	})
+	res = p.GetErrorResponse("GET", msg, protocolErr)
+	assert.Equal(t, res.StatusCode(), http.StatusFound)
+	if assert.True(t, res.NeedsRedirect()) {
+		location, _ := url.Parse(res.GetRedirectTo())
+		assert.Equal(t, location.Host, "rp.example.com")
+		assert.Equal(t, location.Query().Get("session"), "1")
+		assert.Equal(t, location.Query().Get("openid.mode"), "error")
+		assert.Equal(t, location.Query().Get("openid.error"), ErrInvalidCheckIDRequest.Error())
+		assert.Equal(t, location.Query().Get("openid.contact"), "admin@example.com")
+		assert.Equal(t, location.Query().Get("openid.reference"), "ref-1")
+		// error_code is defined for direct error responses only
+		_, ok := location.Query()["openid.error_code"]
+		assert.False(t, ok)
+	}
+
+	// indirect request with return_to in its realm
+	msg, _ = gopenid.MessageFromQuery(url.Values{
+		"openid.ns":        []string{gopenid.NsOpenID20.String()},
+		"openid.mode":      []string{"checkid_setup"},
+		"openid.realm":     []string{"http://*.example.com/"},
+		"openid.return_to": []string{"http://rp.example.com/return"},
+	})
+	res = p.GetErrorResponse("GET", msg, protocolErr)
+	assert.True(t, res.NeedsRedirect())
+
+	// indirect requests without a usable return_to
+	for _, query := range []url.Values{
+		url.Values{
+			"openid.return_to": []string{"javascript:alert(1)"},
+		},
+		url.Values{
+			"openid.realm": []string{"http://rp.example.com/"},
+		},
+		url.Values{
+			"openid.realm":     []string{"http://rp.example.com/"},
+			"openid.return_to": []string{"http://evil.example.org/"},
+		},
+		url.Values{
+			"openid.realm":     []string{"http://*.com/"},
+			"openid.return_to": []string{"http://evil.com/"},
+		},
+		url.Values{
+			"openid.realm":     []string{"http://rp.example.com:99999/"},
+			"openid.return_to": []string{"http://rp.example.com/"},
+		},
+	} {
+		query.Set("openid.ns", gopenid.NsOpenID20.String())
+		query.Set("openid.mode", "checkid_setup")
+		msg, _ = gopenid.MessageFromQuery(query)
+
+		res = p.GetErrorResponse("GET", msg, ErrUnknownMode)
+		assert.False(t, res.NeedsRedirect(), "%v", query)
+		assert.Equal(t, res.StatusCode(), http.StatusBadRequest, "%v", query)
+		assert.Contains(t, strings.Split(string(res.GetBody()), "\n"), "error:"+ErrUnknownMode.Error())
+	}
+
+	// realms rejected by the configured policy
+	strict, err := New(endpoint, newMemoryStore(), WithRealmPolicy(&PublicSuffixRealmPolicy{RequireHTTPS: true}))
+	if assert.Nil(t, err) {
+		msg, _ = gopenid.MessageFromQuery(url.Values{
+			"openid.ns":        []string{gopenid.NsOpenID20.String()},
+			"openid.mode":      []string{"checkid_setup"},
+			"openid.return_to": []string{"http://rp.example.com/return"},
+		})
+		assert.False(t, strict.GetErrorResponse("GET", msg, ErrInsecureRealm).NeedsRedirect())
+	}
+
+	// Err may be omitted
+	assert.Equal(t, (&ProtocolError{ErrorCode: "invalid-request"}).Error(), "invalid-request")
+	assert.Equal(t, (&ProtocolError{}).Error(), "unknown error")
+	assert.Nil(t, (&ProtocolError{}).Unwrap())
+}
@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+// ExtensionHandler adds arguments of an OpenID extension to positive assertions.
+type ExtensionHandler interface {
+	// GetNamespace returns the namespace URI of the extension.
+	GetNamespace() gopenid.NamespaceURI
+	// GetAlias returns the alias the extension is declared with in responses.
+	GetAlias() string
+	// Respond returns arguments to add to the positive assertion for req.
+	// It returns no arguments if the extension was not requested.
+	Respond(req Request, identity string) (map[string]gopenid.MessageValue, error)
+}
+
+func validateExtensionHandlers(handlers []ExtensionHandler) error {
+	var (
+		namespaces = make(map[gopenid.NamespaceURI]bool, len(handlers))
+		aliases    = make(map[string]bool, len(handlers))
+	)
+
+	for _, handler := range handlers {
+		alias := handler.GetAlias()
+		if !gopenid.IsValidNamespaceAlias(alias) {
+			return ErrInvalidExtensionAlias
+		}
+
+		if namespaces[handler.GetNamespace()] || aliases[alias] {
+			return ErrDuplicateExtensionHandler
+		}
+		namespaces[handler.GetNamespace()] = true
+		aliases[alias] = true
+	}
+
+	return nil
+}
+
+// addExtensions adds arguments of extensions to res, and returns keys to sign.
+// If attributes is not nil, only the arguments listed in it are added.
+func addExtensions(handlers []ExtensionHandler, req Request, identity string, attributes map[gopenid.NamespaceURI][]string, res *openIDResponse) (signed []string, err error) {
+	for _, handler := range handlers {
+		var args map[string]gopenid.MessageValue
+		args, err = handler.Respond(req, identity)
+		if err != nil {
+			return
+		}
+
+		if attributes != nil {
+			args = filterExtensionArgs(args, attributes[handler.GetNamespace()])
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		ns := handler.GetNamespace()
+		alias := handler.GetAlias()
+		res.message.SetNamespaceAlias(alias, ns)
+		signed = append(signed, fmt.Sprintf("ns.%s", alias))
+
+		keys := make([]string, 0, len(args))
+		for key := range args {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			res.AddArg(gopenid.NewMessageKey(ns, key), args[key])
+			signed = append(signed, fmt.Sprintf("%s.%s", alias, key))
+		}
+	}
+
+	return
+}
+
+func filterExtensionArgs(args map[string]gopenid.MessageValue, allowed []string) map[string]gopenid.MessageValue {
+	filtered := make(map[string]gopenid.MessageValue, len(allowed))
+	for _, key := range allowed {
+		if value, ok := args[key]; ok {
+			filtered[key] = value
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,254 @@
+package provider
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// goldenVariableFields matches values which differ on every run even with a fixed clock and
+// secret generator: association handles, nonce salts and signatures over them.
+var goldenVariableFields = regexp.MustCompile(`((?:^|[?&;])openid\.(?:assoc_handle|invalidate_handle|response_nonce|sig)=|(?m:^)(?:assoc_handle|invalidate_handle|response_nonce|sig):)[^&\n]*`)
+
+// patternReader is a secret generator repeating a fixed pattern.
+type patternReader struct {
+	offset int
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r.offset % 251)
+		r.offset++
+	}
+	return len(p), nil
+}
+
+func newGoldenProvider(t *testing.T, opts ...Option) *Provider {
+	now := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts = append([]Option{
+		WithClock(func() time.Time { return now }),
+		WithSecretGenerator(&patternReader{}),
+		WithExtensions(&testExtension{alias: "ext"}),
+	}, opts...)
+
+	p, err := New(endpoint, newMemoryStore(), opts...)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return p
+}
+
+// dumpResponse returns res as written to the user agent, with variable values masked.
+func dumpResponse(res Response) []byte {
+	w := httptest.NewRecorder()
+	res.WriteTo(w, httptest.NewRequest("GET", endpoint, nil))
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d\n", w.Code)
+
+	keys := make([]string, 0, len(w.Header()))
+	for key := range w.Header() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range w.Header()[key] {
+			fmt.Fprintf(&b, "%s: %s\n", key, value)
+		}
+	}
+	b.WriteString("\n")
+	b.Write(w.Body.Bytes())
+
+	return goldenVariableFields.ReplaceAll(b.Bytes(), []byte("${1}*"))
+}
+
+func assertGolden(t *testing.T, name string, res Response) {
+	got := dumpResponse(res)
+	path := filepath.Join("testdata", name+".golden")
+
+	if *updateGolden {
+		if err := ioutil.WriteFile(path, got, 0644); !assert.Nil(t, err) {
+			t.FailNow()
+		}
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if assert.Nil(t, err, "run go test -update to create %s", path) {
+		assert.Equal(t, string(got), string(expected), name)
+	}
+
+	// encodings are stable across runs
+	assert.Equal(t, string(dumpResponse(res)), string(got), name)
+}
+
+func goldenMessage(t *testing.T, query url.Values) *gopenid.Message {
+	msg, err := gopenid.MessageFromQuery(query)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return msg
+}
+
+func TestGoldenCheckID(t *testing.T) {
+	p := newGoldenProvider(t)
+
+	query := url.Values{
+		"openid.ns":         []string{gopenid.NsOpenID20.String()},
+		"openid.mode":       []string{"checkid_setup"},
+		"openid.identity":   []string{gopenid.NsIdentifierSelect.String()},
+		"openid.claimed_id": []string{gopenid.NsIdentifierSelect.String()},
+		"openid.realm":      []string{"http://rp.example.com/"},
+		"openid.return_to":  []string{"http://rp.example.com/return?session=1"},
+	}
+
+	s := establishCheckIDSession(t, p, query)
+	s.Accept("http://example.com/users/alice", "")
+	res, err := s.GetResponse()
+	if assert.Nil(t, err) {
+		assertGolden(t, "checkid_id_res", res)
+	}
+
+	s.Reject()
+	res, err = s.GetResponse()
+	if assert.Nil(t, err) {
+		assertGolden(t, "checkid_cancel", res)
+	}
+
+	query.Set("openid.mode", "checkid_immediate")
+	s = establishCheckIDSession(t, p, query)
+	res, err = s.GetResponse()
+	if assert.Nil(t, err) {
+		assertGolden(t, "checkid_setup_needed", res)
+	}
+
+	res, err = p.UnsolicitedAssertion("http://rp.example.com/return", "", "http://example.com/users/alice", "")
+	if assert.Nil(t, err) {
+		assertGolden(t, "unsolicited_id_res", res)
+	}
+
+	assertGolden(t, "redirect", newRedirectResponse("http://example.com/login?continue=1"))
+}
+
+func TestGoldenAssociate(t *testing.T) {
+	p := newGoldenProvider(t)
+
+	// cases run in order, as they share the secret generator
+	for _, testCase := range []struct {
+		name  string
+		query url.Values
+	}{
+		{"associate_no_encryption", url.Values{
+			"openid.ns":           []string{gopenid.NsOpenID20.String()},
+			"openid.mode":         []string{"associate"},
+			"openid.assoc_type":   []string{"HMAC-SHA256"},
+			"openid.session_type": []string{"no-encryption"},
+		}},
+		{"associate_dh_sha256", url.Values{
+			"openid.ns":                 []string{gopenid.NsOpenID20.String()},
+			"openid.mode":               []string{"associate"},
+			"openid.assoc_type":         []string{"HMAC-SHA256"},
+			"openid.session_type":       []string{"DH-SHA256"},
+			"openid.dh_consumer_public": []string{string(gopenid.IntToBase64(big.NewInt(65537)))},
+		}},
+		{"associate_unsupported", url.Values{
+			"openid.ns":           []string{gopenid.NsOpenID20.String()},
+			"openid.mode":         []string{"associate"},
+			"openid.assoc_type":   []string{"HMAC-MD5"},
+			"openid.session_type": []string{"no-encryption"},
+		}},
+	} {
+		session, err := p.EstablishSessionWithTransport("POST", goldenMessage(t, testCase.query), Transport{Secure: true})
+		if !assert.Nil(t, err, testCase.name) {
+			continue
+		}
+
+		res, err := session.GetResponse()
+		if assert.Nil(t, err, testCase.name) {
+			assertGolden(t, testCase.name, res)
+		}
+	}
+}
+
+func TestGoldenCheckAuthentication(t *testing.T) {
+	p := newGoldenProvider(t)
+
+	res, err := p.UnsolicitedAssertion("http://rp.example.com/return", "", "http://example.com/users/alice", "")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	location, _ := url.Parse(res.GetRedirectTo())
+	query := location.Query()
+	query.Set("openid.mode", "check_authentication")
+
+	session, err := p.EstablishSession("POST", goldenMessage(t, query))
+	if assert.Nil(t, err) {
+		res, err := session.GetResponse()
+		if assert.Nil(t, err) {
+			assertGolden(t, "check_authentication", res)
+		}
+	}
+}
+
+func TestGoldenError(t *testing.T) {
+	p := newGoldenProvider(t)
+
+	err := &ProtocolError{
+		Err:       ErrInvalidCheckIDRequest,
+		ErrorCode: "invalid-request",
+		Contact:   "admin@example.com",
+		Reference: "ref-1",
+	}
+
+	assertGolden(t, "error_direct", p.GetErrorResponse("POST", goldenMessage(t, url.Values{
+		"openid.ns":   []string{gopenid.NsOpenID20.String()},
+		"openid.mode": []string{"check_authentication"},
+	}), err))
+	assertGolden(t, "error_indirect", p.GetErrorResponse("GET", goldenMessage(t, url.Values{
+		"openid.ns":        []string{gopenid.NsOpenID20.String()},
+		"openid.mode":      []string{"checkid_setup"},
+		"openid.return_to": []string{"http://rp.example.com/return"},
+	}), err))
+}
+
+func TestGoldenDiscovery(t *testing.T) {
+	p := newGoldenProvider(t)
+
+	assertGolden(t, "yadis_provider_identifier", p.GetYadisProviderIdentifier())
+	assertGolden(t, "yadis_claimed_identifier", p.GetYadisClaimedIdentifier("http://example.com/users/alice"))
+
+	r := httptest.NewRequest("GET", "http://example.com/users/alice", nil)
+	r.Header.Set("Accept", "text/html")
+
+	res, err := p.GetClaimedIdentifier(r, "http://example.com/users/alice", "", "http://example.com/users/alice?xrds", nil)
+	if assert.Nil(t, err) {
+		assertGolden(t, "html_claimed_identifier", res)
+	}
+
+	res, err = p.GetProviderIdentifier(r, "http://example.com/xrds", nil)
+	if assert.Nil(t, err) {
+		assertGolden(t, "html_provider_identifier", res)
+	}
+
+	r.Header.Set("Accept", "application/xrds+xml")
+	res, err = p.GetProviderIdentifier(r, "http://example.com/xrds", nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, res.StatusCode(), http.StatusOK)
+		assertGolden(t, "yadis_provider_identifier_negotiated", res)
+	}
+}
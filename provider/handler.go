@@ -0,0 +1,186 @@
+package provider
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+const (
+	// xrdsQuery is the query of identity pages serving their XRDS documents regardless of Accept.
+	xrdsQuery = "xrds"
+)
+
+// Handler is a http.Handler serving the OP Endpoint, the XRDS document of the OP Identifier,
+// and identity pages of claimed identifiers, including pairwise identifiers of DirectedIdentity.
+//
+// The OP Identifier and identity pages are negotiated by the Accept header, and HTML pages point
+// to their XRDS documents by X-XRDS-Location.
+//
+// checkid requests are answered as Provider.Authorize decides.
+type Handler struct {
+	provider *Provider
+
+	// EndpointPath is the path of the OP Endpoint.
+	EndpointPath string
+	// XRDSPath is the path of the XRDS document of the OP Identifier. It is not served if empty.
+	XRDSPath string
+	// ProviderIdentifierPath is the path of the OP Identifier. It is not served if empty.
+	ProviderIdentifierPath string
+	// IdentityPrefix is the path prefix of identity pages. They are not served if empty.
+	IdentityPrefix string
+	// TrustForwardedProto makes Handler trust X-Forwarded-Proto to detect TLS.
+	TrustForwardedProto bool
+	// DirectedPrefix is the path prefix of pairwise identifiers. They are not served if empty.
+	DirectedPrefix string
+}
+
+// NewHandler returns a new Handler serving p at the path of its endpoint.
+func NewHandler(p *Provider) *Handler {
+	h := &Handler{
+		provider: p,
+	}
+
+	if parsed, err := url.Parse(p.endpoint); err == nil {
+		h.EndpointPath = parsed.Path
+	}
+	if h.EndpointPath == "" {
+		h.EndpointPath = "/"
+	}
+
+	if p.directedIdentity != nil {
+		if parsed, err := url.Parse(p.directedIdentity.GetPrefix()); err == nil {
+			h.DirectedPrefix = parsed.Path
+		}
+	}
+
+	return h
+}
+
+// ServeHTTP dispatches r by its path.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == h.EndpointPath:
+		h.serveEndpoint(w, r)
+	case h.XRDSPath != "" && r.URL.Path == h.XRDSPath:
+		h.respond(w, r, h.provider.GetYadisProviderIdentifier())
+	case h.ProviderIdentifierPath != "" && r.URL.Path == h.ProviderIdentifierPath:
+		h.serveProviderIdentifier(w, r)
+	case h.DirectedPrefix != "" && strings.HasPrefix(r.URL.Path, h.DirectedPrefix) && len(r.URL.Path) > len(h.DirectedPrefix):
+		h.serveDirectedIdentity(w, r)
+	case h.IdentityPrefix != "" && strings.HasPrefix(r.URL.Path, h.IdentityPrefix) && len(r.URL.Path) > len(h.IdentityPrefix):
+		h.serveIdentity(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) serveEndpoint(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.Form
+	if r.Method == "POST" {
+		// OpenID messages sent by POST are in the body only
+		query = r.PostForm
+	}
+
+	msg, err := gopenid.MessageFromQueryWithLimits(query, h.provider.messageLimits)
+	if err != nil {
+		h.respondError(w, r, gopenid.NewMessage(gopenid.NsOpenID20), err)
+		return
+	}
+
+	session, err := h.provider.EstablishSessionWithTransport(
+		r.Method,
+		msg,
+		TransportFromRequest(r, h.TrustForwardedProto),
+	)
+	if err != nil {
+		h.respondError(w, r, msg, err)
+		return
+	}
+
+	var res Response
+	if s, ok := session.(*CheckIDSession); ok {
+		res, err = h.provider.Authorize(r, s)
+	} else {
+		res, err = session.GetResponse()
+	}
+	if err != nil {
+		h.respondError(w, r, msg, err)
+		return
+	}
+
+	h.respond(w, r, res)
+}
+
+func (h *Handler) serveProviderIdentifier(w http.ResponseWriter, r *http.Request) {
+	var xrdsLocation string
+	if h.XRDSPath != "" {
+		xrdsLocation = h.resolveURL(h.XRDSPath)
+	}
+
+	res, err := h.provider.GetProviderIdentifier(r, xrdsLocation, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.respond(w, r, res)
+}
+
+func (h *Handler) serveIdentity(w http.ResponseWriter, r *http.Request) {
+	h.serveClaimedIdentifier(w, r, h.resolveURL(r.URL.Path))
+}
+
+func (h *Handler) serveDirectedIdentity(w http.ResponseWriter, r *http.Request) {
+	directed := h.provider.directedIdentity
+	identifier := directed.GetPrefix() + r.URL.Path[len(h.DirectedPrefix):]
+	if _, err := directed.Resolve(identifier); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.serveClaimedIdentifier(w, r, identifier)
+}
+
+// serveClaimedIdentifier serves the identity page of identifier, or its XRDS document
+// if asked for by xrdsQuery.
+func (h *Handler) serveClaimedIdentifier(w http.ResponseWriter, r *http.Request, identifier string) {
+	if r.URL.RawQuery == xrdsQuery {
+		h.respond(w, r, h.provider.GetYadisClaimedIdentifier(identifier))
+		return
+	}
+
+	res, err := h.provider.GetClaimedIdentifier(r, identifier, identifier, identifier+"?"+xrdsQuery, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.respond(w, r, res)
+}
+
+// resolveURL returns the absolute URL of path on the host of the OP Endpoint.
+func (h *Handler) resolveURL(path string) string {
+	base, err := url.Parse(h.provider.endpoint)
+	if err != nil {
+		return path
+	}
+
+	return base.ResolveReference(&url.URL{Path: path}).String()
+}
+
+func (h *Handler) respond(w http.ResponseWriter, r *http.Request, res Response) {
+	res.WriteTo(w, r)
+}
+
+// respondError sends the error response of Provider.GetErrorResponse.
+func (h *Handler) respondError(w http.ResponseWriter, r *http.Request, msg *gopenid.Message, err error) {
+	h.respond(w, r, h.provider.GetErrorResponse(r.Method, msg, err))
+}
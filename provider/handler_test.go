@@ -0,0 +1,231 @@
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHandler(t *testing.T, opts ...Option) *Handler {
+	p, err := New("http://example.com/openid", newMemoryStore(), opts...)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	h := NewHandler(p)
+	h.XRDSPath = "/xrds"
+	h.IdentityPrefix = "/users/"
+	return h
+}
+
+func serveTestRequest(h http.Handler, method, target string, query url.Values) *httptest.ResponseRecorder {
+	var r *http.Request
+	if method == "POST" {
+		r = httptest.NewRequest(method, target, strings.NewReader(query.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, target+"?"+query.Encode(), nil)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandlerCheckID(t *testing.T) {
+	var (
+		loggedIn bool
+		approved bool
+	)
+
+	h := newTestHandler(t,
+		WithAuthenticator(AuthenticatorFunc(func(req *AuthRequest) (AuthResult, error) {
+			if !loggedIn {
+				return InteractResult("http://example.com/login"), nil
+			}
+			return AcceptResult("http://example.com/users/alice", ""), nil
+		})),
+		WithConsentDecider(ConsentDeciderFunc(func(req *AuthRequest, identity string) (AuthResult, error) {
+			if !approved {
+				return RejectResult(), nil
+			}
+			return AcceptResult("", ""), nil
+		})),
+	)
+
+	query := url.Values{
+		"openid.ns":         []string{gopenid.NsOpenID20.String()},
+		"openid.mode":       []string{"checkid_setup"},
+		"openid.identity":   []string{gopenid.NsIdentifierSelect.String()},
+		"openid.claimed_id": []string{gopenid.NsIdentifierSelect.String()},
+		"openid.return_to":  []string{"http://rp.example.com/return"},
+	}
+
+	// nobody is logged in
+	w := serveTestRequest(h, "GET", "/openid", query)
+	if assert.Equal(t, w.Code, http.StatusFound) {
+		assert.Equal(t, w.Header().Get("Location"), "http://example.com/login")
+	}
+
+	// the user declined
+	loggedIn = true
+	w = serveTestRequest(h, "GET", "/openid", query)
+	if assert.Equal(t, w.Code, http.StatusFound) {
+		location, _ := url.Parse(w.Header().Get("Location"))
+		assert.Equal(t, location.Host, "rp.example.com")
+		assert.Equal(t, location.Query().Get("openid.mode"), "cancel")
+	}
+
+	// the user approved
+	approved = true
+	w = serveTestRequest(h, "GET", "/openid", query)
+	if assert.Equal(t, w.Code, http.StatusFound) {
+		location, _ := url.Parse(w.Header().Get("Location"))
+		assert.Equal(t, location.Query().Get("openid.mode"), "id_res")
+		assert.Equal(t, location.Query().Get("openid.claimed_id"), "http://example.com/users/alice")
+		assert.NotEqual(t, location.Query().Get("openid.sig"), "")
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	h := newTestHandler(t)
+
+	// indirect request with return_to
+	w := serveTestRequest(h, "GET", "/openid", url.Values{
+		"openid.ns":        []string{gopenid.NsOpenID20.String()},
+		"openid.mode":      []string{"checkid_setup"},
+		"openid.identity":  []string{gopenid.NsIdentifierSelect.String()},
+		"openid.return_to": []string{"http://rp.example.com/return"},
+	})
+	if assert.Equal(t, w.Code, http.StatusFound) {
+		location, _ := url.Parse(w.Header().Get("Location"))
+		assert.Equal(t, location.Query().Get("openid.mode"), "error")
+		assert.Equal(t, location.Query().Get("openid.error"), ErrInvalidCheckIDRequest.Error())
+	}
+
+	// realm over a public suffix is never redirected to
+	w = serveTestRequest(h, "GET", "/openid", url.Values{
+		"openid.ns":         []string{gopenid.NsOpenID20.String()},
+		"openid.mode":       []string{"checkid_setup"},
+		"openid.identity":   []string{gopenid.NsIdentifierSelect.String()},
+		"openid.claimed_id": []string{gopenid.NsIdentifierSelect.String()},
+		"openid.realm":      []string{"http://*.co.uk/"},
+		"openid.return_to":  []string{"http://rp.example.co.uk/return"},
+	})
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	assert.Equal(t, w.Header().Get("Location"), "")
+	assert.Contains(t, strings.Split(w.Body.String(), "\n"), "error:"+ErrPublicSuffixRealm.Error())
+
+	// indirect request without return_to
+	w = serveTestRequest(h, "GET", "/openid", url.Values{
+		"openid.ns":   []string{gopenid.NsOpenID20.String()},
+		"openid.mode": []string{"unknown"},
+	})
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	assert.Equal(t, w.Header().Get("Location"), "")
+
+	// direct request
+	w = serveTestRequest(h, "POST", "/openid", url.Values{
+		"openid.ns":           []string{gopenid.NsOpenID20.String()},
+		"openid.mode":         []string{"check_authentication"},
+		"openid.assoc_handle": []string{"handle"},
+	})
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	for _, line := range []string{
+		"ns:" + gopenid.NsOpenID20.String(),
+		"mode:error",
+		"error:" + ErrInvalidCheckAuthenticationRequest.Error(),
+	} {
+		assert.Contains(t, strings.Split(w.Body.String(), "\n"), line)
+	}
+}
+
+func TestHandlerYadis(t *testing.T) {
+	h := newTestHandler(t)
+
+	w := serveTestRequest(h, "GET", "/xrds", nil)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/xrds+xml")
+	assert.Contains(t, w.Body.String(), gopenid.NsOpenID20Server.String())
+
+	w = serveTestRequest(h, "GET", "/users/alice", nil)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Contains(t, w.Body.String(), "<LocalID>http://example.com/users/alice</LocalID>")
+
+	w = serveTestRequest(h, "GET", "/users/", nil)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
+
+func TestHandlerNegotiation(t *testing.T) {
+	h := newTestHandler(t)
+	h.ProviderIdentifierPath = "/"
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "http://example.com"+path, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	const browser = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	w := get("/", browser)
+	if assert.Equal(t, w.Code, http.StatusOK) {
+		assert.Equal(t, w.Header().Get("Content-Type"), "text/html;charset=utf-8")
+		assert.Equal(t, w.Header().Get("X-XRDS-Location"), "http://example.com/xrds")
+		assert.Equal(t, w.Header().Get("Vary"), "Accept")
+		assert.Contains(t, w.Body.String(), `<meta http-equiv="X-XRDS-Location" content="http://example.com/xrds">`)
+	}
+
+	w = get("/", "application/xrds+xml")
+	if assert.Equal(t, w.Code, http.StatusOK) {
+		assert.Equal(t, w.Header().Get("Content-Type"), "application/xrds+xml")
+		assert.Equal(t, w.Header().Get("X-XRDS-Location"), "")
+		assert.Contains(t, w.Body.String(), gopenid.NsOpenID20Server.String())
+	}
+
+	w = get("/users/alice", browser)
+	if assert.Equal(t, w.Code, http.StatusOK) {
+		assert.Equal(t, w.Header().Get("Content-Type"), "text/html;charset=utf-8")
+		assert.Equal(t, w.Header().Get("X-XRDS-Location"), "http://example.com/users/alice?xrds")
+		assert.Contains(t, w.Body.String(), `<link rel="openid2.provider" href="http://example.com/openid">`)
+	}
+
+	for _, accept := range []string{
+		"",
+		"application/xrds+xml",
+		"text/html;q=0.5, application/xrds+xml",
+		"text/html, application/*",
+	} {
+		w = get("/users/alice", accept)
+		assert.Equal(t, w.Header().Get("Content-Type"), "application/xrds+xml", accept)
+	}
+
+	w = get("/users/alice?xrds", browser)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/xrds+xml")
+}
+
+func TestAcceptQuality(t *testing.T) {
+	for _, testCase := range []struct {
+		accept  string
+		quality float64
+	}{
+		{"application/xrds+xml", 1},
+		{"text/html", 0},
+		{"*/*;q=0.3", 0.3},
+		{"application/*;q=0.5, */*;q=0.1", 0.5},
+		{"application/xrds+xml;q=0, */*", 0},
+		{"Application/XRDS+XML; q=0.7", 0.7},
+	} {
+		assert.Equal(t, acceptQuality(testCase.accept, xrdsContentType), testCase.quality, testCase.accept)
+	}
+}
@@ -0,0 +1,163 @@
+package provider
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+)
+
+// DefaultIdentityTemplate is the template of identity pages used unless Config.IdentityTemplate is set.
+var DefaultIdentityTemplate = template.Must(template.New("identity").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{.Head}}
+<title>{{.ClaimedID}}</title>
+</head>
+<body>
+<p>This is an OpenID identifier: {{.ClaimedID}}</p>
+</body>
+</html>
+`))
+
+// DefaultProviderTemplate is the template of the OP Identifier page used unless Config.ProviderTemplate is set.
+var DefaultProviderTemplate = template.Must(template.New("provider").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{.Head}}
+<title>OpenID Provider</title>
+</head>
+<body>
+<p>This is an OpenID Provider.</p>
+</body>
+</html>
+`))
+
+var providerHeadTemplate = template.Must(template.New("head").Parse(
+	`{{if .XRDSLocation}}<meta http-equiv="X-XRDS-Location" content="{{.XRDSLocation}}">
+{{end}}`))
+
+var discoveryHeadTemplate = template.Must(template.New("head").Parse(
+	`{{if .XRDSLocation}}<meta http-equiv="X-XRDS-Location" content="{{.XRDSLocation}}">
+{{end}}<link rel="openid2.provider" href="{{.Endpoint}}">
+{{if .LocalID}}<link rel="openid2.local_id" href="{{.LocalID}}">
+{{end}}<link rel="openid.server" href="{{.Endpoint}}">
+{{if .LocalID}}<link rel="openid.delegate" href="{{.LocalID}}">
+{{end}}`))
+
+// IdentityPage is the data given to templates of identity pages.
+type IdentityPage struct {
+	// Endpoint is the URL of the OP Endpoint.
+	Endpoint string
+	// ClaimedID is the identifier the page is served at.
+	ClaimedID string
+	// LocalID is the OP-local identifier ClaimedID delegates to. It is empty unless they differ.
+	LocalID string
+	// XRDSLocation is the URL of the XRDS document of ClaimedID, if any.
+	XRDSLocation string
+	// Head is the elements for Yadis and HTML-based discovery. Templates must put it in the head element.
+	Head template.HTML
+	// Data is the value given to Provider.GetHTMLClaimedIdentifier.
+	Data interface{}
+}
+
+func newIdentityPage(endpoint, claimedID, localID, xrdsLocation string, data interface{}) (page *IdentityPage, err error) {
+	if localID == claimedID {
+		localID = ""
+	}
+
+	page = &IdentityPage{
+		Endpoint:     endpoint,
+		ClaimedID:    claimedID,
+		LocalID:      localID,
+		XRDSLocation: xrdsLocation,
+		Data:         data,
+	}
+
+	var head bytes.Buffer
+	if err = discoveryHeadTemplate.Execute(&head, page); err != nil {
+		return
+	}
+	page.Head = template.HTML(head.String())
+
+	return
+}
+
+// ProviderPage is the data given to templates of the OP Identifier page.
+type ProviderPage struct {
+	// Endpoint is the URL of the OP Endpoint.
+	Endpoint string
+	// XRDSLocation is the URL of the XRDS document of the OP Identifier, if any.
+	XRDSLocation string
+	// Head is the elements for Yadis discovery. Templates must put it in the head element.
+	Head template.HTML
+	// Data is the value given to Provider.GetProviderIdentifier.
+	Data interface{}
+}
+
+func newProviderPage(endpoint, xrdsLocation string, data interface{}) (page *ProviderPage, err error) {
+	page = &ProviderPage{
+		Endpoint:     endpoint,
+		XRDSLocation: xrdsLocation,
+		Data:         data,
+	}
+
+	var head bytes.Buffer
+	if err = providerHeadTemplate.Execute(&head, page); err != nil {
+		return
+	}
+	page.Head = template.HTML(head.String())
+
+	return
+}
+
+type htmlResponse struct {
+	body   []byte
+	header http.Header
+}
+
+func newHTMLResponse(tmpl *template.Template, data interface{}) (res *htmlResponse, err error) {
+	var body bytes.Buffer
+	if err = tmpl.Execute(&body, data); err != nil {
+		return
+	}
+
+	res = &htmlResponse{
+		body:   body.Bytes(),
+		header: make(http.Header),
+	}
+	return
+}
+
+func (res *htmlResponse) NeedsRedirect() bool {
+	return false
+}
+
+func (res *htmlResponse) IsPermanently() bool {
+	return false
+}
+
+func (res *htmlResponse) GetRedirectTo() string {
+	return ""
+}
+
+func (res *htmlResponse) GetBody() []byte {
+	return res.body
+}
+
+func (res *htmlResponse) GetContentType() string {
+	return "text/html;charset=utf-8"
+}
+
+func (res *htmlResponse) StatusCode() int {
+	return http.StatusOK
+}
+
+func (res *htmlResponse) Header() http.Header {
+	return res.header
+}
+
+func (res *htmlResponse) WriteTo(w http.ResponseWriter, r *http.Request) {
+	writeResponse(res, w, r)
+}
@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetHTMLClaimedIdentifier(t *testing.T) {
+	p, err := New(endpoint, newMemoryStore())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	res, err := p.GetHTMLClaimedIdentifier("http://example.com/alice", "http://example.com/alice", nil)
+	if assert.Nil(t, err) {
+		body := string(res.GetBody())
+		assert.Equal(t, res.GetContentType(), "text/html;charset=utf-8")
+		assert.Contains(t, body, `<link rel="openid2.provider" href="http://example.com/">`)
+		assert.Contains(t, body, `<link rel="openid.server" href="http://example.com/">`)
+		assert.NotContains(t, body, "local_id")
+		assert.NotContains(t, body, "delegate")
+		assert.True(t, strings.Index(body, "openid2.provider") < strings.Index(body, "</head>"))
+	}
+
+	res, err = p.GetHTMLClaimedIdentifier("http://alice.example.net/", "http://example.com/alice?a=b&c", nil)
+	if assert.Nil(t, err) {
+		body := string(res.GetBody())
+		assert.Contains(t, body, `<link rel="openid2.local_id" href="http://example.com/alice?a=b&amp;c">`)
+		assert.Contains(t, body, `<link rel="openid.delegate" href="http://example.com/alice?a=b&amp;c">`)
+	}
+
+	tmpl := template.Must(template.New("profile").Parse(`<head>{{.Head}}</head><h1>{{.Data}}</h1>`))
+	p, err = New(endpoint, newMemoryStore(), WithIdentityTemplate(tmpl))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	res, err = p.GetHTMLClaimedIdentifier("http://example.com/alice", "", "<Alice>")
+	if assert.Nil(t, err) {
+		body := string(res.GetBody())
+		assert.True(t, strings.HasPrefix(body, `<head><link rel="openid2.provider"`))
+		assert.True(t, strings.HasSuffix(body, `</head><h1>&lt;Alice&gt;</h1>`))
+	}
+
+	p, err = New(endpoint, newMemoryStore(), WithIdentityTemplate(template.Must(template.New("broken").Parse(`{{.Unknown}}`))))
+	if assert.Nil(t, err) {
+		_, err = p.GetHTMLClaimedIdentifier("http://example.com/alice", "", nil)
+		assert.NotNil(t, err)
+	}
+}
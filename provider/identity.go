@@ -0,0 +1,76 @@
+package provider
+
+import (
+	"errors"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+var (
+	ErrUnknownIdentity       = errors.New("unknown identity")
+	ErrDelegationNotVerified = errors.New("claimed identifier does not delegate to the identity")
+)
+
+// IdentityResolver maps OP-local identifiers to accounts, and verifies delegation of claimed identifiers.
+// Identifiers given to it are normalized by gopenid.NormalizeIdentifier.
+type IdentityResolver interface {
+	// ResolveLocalID returns the ID of the account owning the OP-local identifier localID,
+	// or ErrUnknownIdentity.
+	ResolveLocalID(localID string) (userID string, err error)
+	// VerifyDelegation reports whether claimedID delegates to localID, i.e. discovery on claimedID
+	// yields localID as the OP-local identifier for the OP.
+	VerifyDelegation(claimedID, localID string) (bool, error)
+}
+
+// normalizeIdentifier returns identifier normalized by gopenid.NormalizeIdentifier,
+// or identifier as is if it cannot be normalized.
+func normalizeIdentifier(identifier string) string {
+	if normalized, _, err := gopenid.NormalizeIdentifier(identifier); err == nil {
+		return normalized
+	}
+	return identifier
+}
+
+// sameIdentifier reports whether identifiers a and b are the same in their normalized forms.
+func sameIdentifier(a, b string) bool {
+	return a == b || normalizeIdentifier(a) == normalizeIdentifier(b)
+}
+
+// sameAccount reports whether identifiers a and b belong to the same account.
+// Normalized identifiers are given to resolver.
+func sameAccount(resolver IdentityResolver, a, b string) (bool, error) {
+	a, b = normalizeIdentifier(a), normalizeIdentifier(b)
+
+	userA, err := resolver.ResolveLocalID(a)
+	if err == ErrUnknownIdentity {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	userB, err := resolver.ResolveLocalID(b)
+	if err == ErrUnknownIdentity {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return userA == userB, nil
+}
+
+// verifyDelegation returns ErrDelegationNotVerified unless claimedID is localID itself,
+// or delegates to localID according to resolver.
+func verifyDelegation(resolver IdentityResolver, claimedID, localID string) error {
+	if resolver == nil || claimedID == "" || sameIdentifier(claimedID, localID) {
+		return nil
+	}
+
+	ok, err := resolver.VerifyDelegation(normalizeIdentifier(claimedID), normalizeIdentifier(localID))
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrDelegationNotVerified
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+type testIdentityResolver struct {
+	accounts    map[string]string
+	delegations map[string]string
+}
+
+func (r *testIdentityResolver) ResolveLocalID(localID string) (string, error) {
+	userID, ok := r.accounts[localID]
+	if !ok {
+		return "", ErrUnknownIdentity
+	}
+	return userID, nil
+}
+
+func (r *testIdentityResolver) VerifyDelegation(claimedID, localID string) (bool, error) {
+	return r.delegations[claimedID] == localID, nil
+}
+
+func TestIdentityResolver(t *testing.T) {
+	p, err := New(endpoint, newMemoryStore(), WithIdentityResolver(&testIdentityResolver{
+		accounts: map[string]string{
+			"http://example.com/users/alice": "alice",
+			"http://example.com/~alice":      "alice",
+			"http://example.com/users/bob":   "bob",
+		},
+		delegations: map[string]string{
+			"http://alice.example.net/": "http://example.com/users/alice",
+		},
+	}))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	for _, testCase := range []struct {
+		ns        gopenid.NamespaceURI
+		identity  string
+		claimedID string
+		accepted  string
+
+		err               error
+		expectedIdentity  string
+		expectedClaimedID string
+	}{
+		// delegated
+		{
+			gopenid.NsOpenID20, "http://example.com/users/alice", "http://alice.example.net/", "http://example.com/users/alice",
+			nil, "http://example.com/users/alice", "http://alice.example.net/",
+		},
+		// another identifier of the same account
+		{
+			gopenid.NsOpenID20, "http://example.com/~alice", "http://example.com/~alice", "http://example.com/users/alice",
+			nil, "http://example.com/~alice", "http://example.com/~alice",
+		},
+		// delegated to another identifier of the same account
+		{
+			gopenid.NsOpenID20, "http://example.com/~alice", "http://alice.example.net/", "http://example.com/users/alice",
+			ErrDelegationNotVerified, "", "",
+		},
+		{
+			gopenid.NsOpenID20, "http://example.com/users/bob", "http://alice.example.net/", "http://example.com/users/bob",
+			ErrDelegationNotVerified, "", "",
+		},
+		{
+			gopenid.NsOpenID20, "http://example.com/users/bob", "http://example.com/users/bob", "http://example.com/users/alice",
+			ErrIdentityNotMatched, "", "",
+		},
+		{
+			gopenid.NsOpenID20, "http://example.com/users/carol", "http://example.com/users/carol", "http://example.com/users/alice",
+			ErrIdentityNotMatched, "", "",
+		},
+		// OP chose the delegated identifier
+		{
+			gopenid.NsOpenID20, gopenid.NsIdentifierSelect.String(), gopenid.NsIdentifierSelect.String(), "http://example.com/users/alice",
+			nil, "http://example.com/users/alice", "http://example.com/users/alice",
+		},
+		// OpenID 1.x relying parties keep the claimed identifier
+		{
+			gopenid.NsOpenID11, "http://example.com/~alice", "http://alice.example.net/", "http://example.com/users/alice",
+			nil, "http://example.com/~alice", "",
+		},
+	} {
+		query := url.Values{
+			"openid.mode":       []string{"checkid_setup"},
+			"openid.identity":   []string{testCase.identity},
+			"openid.claimed_id": []string{testCase.claimedID},
+			"openid.return_to":  []string{"http://rp.example.com/return"},
+		}
+		if testCase.ns == gopenid.NsOpenID20 {
+			query.Set("openid.ns", testCase.ns.String())
+		}
+
+		s := establishCheckIDSession(t, p, query)
+		s.Accept(testCase.accepted, "")
+
+		res, err := s.GetResponse()
+		if !assert.Equal(t, err, testCase.err, "%+v", testCase) || err != nil {
+			continue
+		}
+
+		location, _ := url.Parse(res.GetRedirectTo())
+		assert.Equal(t, location.Query().Get("openid.identity"), testCase.expectedIdentity)
+		assert.Equal(t, location.Query().Get("openid.claimed_id"), testCase.expectedClaimedID)
+	}
+}
+
+func TestAcceptNormalizedIdentity(t *testing.T) {
+	p, err := New(endpoint, newMemoryStore())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	s := establishCheckIDSession(t, p, url.Values{
+		"openid.ns":         []string{gopenid.NsOpenID20.String()},
+		"openid.mode":       []string{"checkid_setup"},
+		"openid.identity":   []string{"http://example.com/%7Ealice"},
+		"openid.claimed_id": []string{"http://example.com/%7Ealice"},
+		"openid.return_to":  []string{"http://rp.example.com/return"},
+	})
+
+	s.Accept("HTTP://Example.com:80/~alice#me", "")
+	res, err := s.GetResponse()
+	if assert.Nil(t, err) {
+		// identifiers are asserted as requested
+		location, _ := url.Parse(res.GetRedirectTo())
+		assert.Equal(t, location.Query().Get("openid.identity"), "http://example.com/%7Ealice")
+		assert.Equal(t, location.Query().Get("openid.claimed_id"), "http://example.com/%7Ealice")
+	}
+
+	s.Accept("http://example.com/~bob", "")
+	_, err = s.GetResponse()
+	assert.Equal(t, err, ErrIdentityNotMatched)
+}
@@ -1,8 +1,11 @@
 package provider
 
 import (
+	"crypto/rand"
 	"github.com/GehirnInc/GOpenID"
+	"html/template"
 	"io"
+	"log"
 	"time"
 )
 
@@ -11,33 +14,183 @@ type Provider struct {
 	signer        *Signer
 	endpoint      string
 	assocLifetime time.Duration
+	assocPairs    associationPairs
+	stateLifetime time.Duration
+
+	allowInsecureNoEncryption bool
+	messageLimits             gopenid.MessageLimits
+	maxDHModulusBits          int
+
+	nonceWindow time.Duration
+	clock       func() time.Time
+	logger      *log.Logger
+	realmPolicy RealmPolicy
+	extensions  []ExtensionHandler
+
+	authenticator  Authenticator
+	consentDecider ConsentDecider
+	trustStore     TrustStore
+
+	directedIdentity *DirectedIdentity
+	identityResolver IdentityResolver
+	identityTemplate *template.Template
+	providerTemplate *template.Template
 }
 
-func NewProvider(endpoint string, store gopenid.Store, lifetime time.Duration, secretGenerator io.Reader) *Provider {
-	signer := NewSigner(store, lifetime, secretGenerator)
+// New returns a new Provider serving endpoint with store, modified by opts.
+// It returns an error if the resulting Config is invalid.
+func New(endpoint string, store gopenid.Store, opts ...Option) (*Provider, error) {
+	config := NewConfig(endpoint, store)
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	return NewWithConfig(config)
+}
+
+// NewWithConfig returns a new Provider configured by config.
+// It returns an error if config is invalid.
+func NewWithConfig(config *Config) (*Provider, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return newProvider(config), nil
+}
+
+func newProvider(config *Config) *Provider {
+	signer := NewSigner(config.Store, config.AssociationLifetime, config.SecretGenerator)
+	signer.statelessLifetime = config.StatelessLifetime
+	signer.clock = config.Clock
+	signer.SetKeyring(config.Keyring)
+	signer.SetMACSigner(config.MACSigner)
+
+	assocPairs, _ := newAssociationPairs(config.AllowedAssociations)
+	signer.assocPairs = assocPairs
+	extensions := make([]ExtensionHandler, len(config.Extensions))
+	copy(extensions, config.Extensions)
+
+	identityTemplate := config.IdentityTemplate
+	if identityTemplate == nil {
+		identityTemplate = DefaultIdentityTemplate
+	}
+	providerTemplate := config.ProviderTemplate
+	if providerTemplate == nil {
+		providerTemplate = DefaultProviderTemplate
+	}
 
 	return &Provider{
-		store:         store,
+		store:         config.Store,
 		signer:        signer,
-		endpoint:      endpoint,
-		assocLifetime: lifetime,
+		endpoint:      config.Endpoint,
+		assocLifetime: config.AssociationLifetime,
+		assocPairs:    assocPairs,
+		stateLifetime: config.StateLifetime,
+
+		allowInsecureNoEncryption: config.AllowInsecureNoEncryption,
+		messageLimits:             config.MessageLimits,
+		maxDHModulusBits:          config.MaxDHModulusBits,
+
+		nonceWindow: config.NonceWindow,
+		clock:       config.Clock,
+		logger:      config.Logger,
+		realmPolicy: config.RealmPolicy,
+		extensions:  extensions,
+
+		authenticator:  config.Authenticator,
+		consentDecider: config.ConsentDecider,
+		trustStore:     config.TrustStore,
+
+		directedIdentity: config.DirectedIdentity,
+		identityResolver: config.IdentityResolver,
+		identityTemplate: identityTemplate,
+		providerTemplate: providerTemplate,
+	}
+}
+
+// NewProvider is a compatibility wrapper of New.
+// As it always did, it does not validate the given arguments, but a nil secretGenerator
+// is replaced with crypto/rand.Reader. Use New to have them validated.
+func NewProvider(endpoint string, store gopenid.Store, lifetime time.Duration, secretGenerator io.Reader) *Provider {
+	if secretGenerator == nil {
+		secretGenerator = rand.Reader
+	}
+
+	config := NewConfig(endpoint, store)
+	config.AssociationLifetime = lifetime
+	config.StatelessLifetime = lifetime
+	config.SecretGenerator = secretGenerator
+
+	return newProvider(config)
+}
+
+func (p *Provider) now() time.Time {
+	return p.clock()
+}
+
+func (p *Provider) logf(format string, v ...interface{}) {
+	if p.logger != nil {
+		p.logger.Printf(format, v...)
 	}
 }
 
-func (p *Provider) EstablishSession(method string, msg gopenid.Message) (Session, error) {
+// SetKeyring makes p protect association secrets in its store with keyring.
+// It is not safe to call while p serves requests.
+func (p *Provider) SetKeyring(keyring *gopenid.Keyring) {
+	p.signer.SetKeyring(keyring)
+}
+
+// SetMACSigner makes p compute signatures with macSigner instead of in-process HMAC.
+// It is not safe to call while p serves requests.
+func (p *Provider) SetMACSigner(macSigner gopenid.MACSigner) {
+	p.signer.SetMACSigner(macSigner)
+}
+
+// SetAllowedAssociations restricts combinations of session type and association type
+// p establishes associations with. pairs are ordered by preference, and the first
+// suitable one is advertised to relying parties which requested an unsupported pair.
+// If pairs are rejected, the previous configuration is kept.
+// It is not safe to call while p serves requests.
+func (p *Provider) SetAllowedAssociations(pairs ...AssociationPair) (err error) {
+	assocPairs, err := newAssociationPairs(pairs)
+	if err != nil {
+		return
+	}
+
+	p.assocPairs = assocPairs
+	p.signer.assocPairs = assocPairs
+	return
+}
+
+// EstablishSession is same as EstablishSessionWithTransport, but it assumes msg arrived over an insecure transport.
+func (p *Provider) EstablishSession(method string, msg *gopenid.Message) (Session, error) {
 	return SessionFromMessage(p, method, msg)
 }
 
+// EstablishSessionWithTransport returns Session for msg which arrived over transport.
+func (p *Provider) EstablishSessionWithTransport(method string, msg *gopenid.Message, transport Transport) (Session, error) {
+	return sessionFromMessage(p, method, msg, transport)
+}
+
 func (p *Provider) GetYadisProviderIdentifier() Response {
+	return p.yadisProviderIdentifier()
+}
+
+func (p *Provider) yadisProviderIdentifier() *yadisResponse {
+	priority := 1
 	et := &gopenid.XRDSDocument{
-		XRD: gopenid.XRDSXRDElement{
-			Services: []gopenid.XRDSServiceElement{
-				gopenid.XRDSServiceElement{
-					Priority: 1,
-					Type: []string{
-						gopenid.NsOpenID20Server.String(),
+		XRDs: []gopenid.XRDSXRDElement{
+			gopenid.XRDSXRDElement{
+				Services: []gopenid.XRDSServiceElement{
+					gopenid.XRDSServiceElement{
+						Priority: &priority,
+						Type: []string{
+							gopenid.NsOpenID20Server.String(),
+						},
+						URIs: []gopenid.XRDSURIElement{
+							gopenid.XRDSURIElement{Value: p.endpoint},
+						},
 					},
-					URI: p.endpoint,
 				},
 			},
 		},
@@ -47,16 +200,25 @@ func (p *Provider) GetYadisProviderIdentifier() Response {
 }
 
 func (p *Provider) GetYadisClaimedIdentifier(localid string) Response {
+	return p.yadisClaimedIdentifier(localid)
+}
+
+func (p *Provider) yadisClaimedIdentifier(localid string) *yadisResponse {
+	priority := 1
 	et := &gopenid.XRDSDocument{
-		XRD: gopenid.XRDSXRDElement{
-			Services: []gopenid.XRDSServiceElement{
-				gopenid.XRDSServiceElement{
-					Priority: 1,
-					Type: []string{
-						gopenid.NsOpenID20Signon.String(),
+		XRDs: []gopenid.XRDSXRDElement{
+			gopenid.XRDSXRDElement{
+				Services: []gopenid.XRDSServiceElement{
+					gopenid.XRDSServiceElement{
+						Priority: &priority,
+						Type: []string{
+							gopenid.NsOpenID20Signon.String(),
+						},
+						URIs: []gopenid.XRDSURIElement{
+							gopenid.XRDSURIElement{Value: p.endpoint},
+						},
+						LocalID: localid,
 					},
-					URI:     p.endpoint,
-					LocalID: localid,
 				},
 			},
 		},
@@ -64,3 +226,15 @@ func (p *Provider) GetYadisClaimedIdentifier(localid string) Response {
 
 	return newYadisResponse(et)
 }
+
+// GetHTMLClaimedIdentifier returns the identity page of claimedID for HTML-based discovery,
+// rendered by the identity template with IdentityPage carrying data.
+// localID is the OP-local identifier claimedID delegates to, or claimedID itself.
+func (p *Provider) GetHTMLClaimedIdentifier(claimedID, localID string, data interface{}) (Response, error) {
+	page, err := newIdentityPage(p.endpoint, claimedID, localID, "", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHTMLResponse(p.identityTemplate, page)
+}
@@ -0,0 +1,140 @@
+package provider
+
+import (
+	_ "embed"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+var (
+	ErrPublicSuffixRealm = errors.New("realm wildcard covers a public suffix")
+	ErrIPLiteralRealm    = errors.New("realm host is an IP literal")
+	ErrInsecureRealm     = errors.New("realm is not https")
+)
+
+// publicSuffixList is the Public Suffix List, https://publicsuffix.org/list/public_suffix_list.dat.
+//
+//go:embed public_suffix_list.dat
+var publicSuffixList string
+
+// defaultRealmPolicy is applied to all checkid requests.
+var defaultRealmPolicy RealmPolicy = new(PublicSuffixRealmPolicy)
+
+// PublicSuffixRealmPolicy is a RealmPolicy rejecting wildcard realms over public suffixes
+// such as *.com and *.co.uk, which would let a relying party obtain assertions for every site
+// under them. Public suffixes are looked up in the embedded Public Suffix List.
+//
+// Wildcards over public suffixes are rejected for all checkid requests. Set
+// PublicSuffixRealmPolicy with stricter options by WithRealmPolicy to reject more realms.
+type PublicSuffixRealmPolicy struct {
+	// RejectIPLiterals rejects realms whose host is an IPv4 or IPv6 address.
+	RejectIPLiterals bool
+	// RequireHTTPS rejects realms whose scheme is not https.
+	RequireHTTPS bool
+}
+
+// CheckRealm returns ErrPublicSuffixRealm, ErrIPLiteralRealm or ErrInsecureRealm if realm is
+// not acceptable, nil otherwise.
+func (policy *PublicSuffixRealmPolicy) CheckRealm(realm Realm) error {
+	host := realm.Host
+	if realm.Wildcard {
+		host = host[1:]
+	}
+
+	if policy.RequireHTTPS && realm.Scheme != "https" {
+		return ErrInsecureRealm
+	} else if net.ParseIP(host) != nil {
+		if policy.RejectIPLiterals || realm.Wildcard {
+			return ErrIPLiteralRealm
+		}
+		return nil
+	} else if realm.Wildcard && (isPublicSuffix(host) || hasPublicSuffixChildren(host)) {
+		return ErrPublicSuffixRealm
+	}
+
+	return nil
+}
+
+// checkRealm checks realm by defaultRealmPolicy, and by policy if not nil.
+func checkRealm(realm Realm, policy RealmPolicy) error {
+	if err := defaultRealmPolicy.CheckRealm(realm); err != nil {
+		return err
+	} else if policy != nil {
+		return policy.CheckRealm(realm)
+	}
+	return nil
+}
+
+type publicSuffixRules struct {
+	exact      map[string]bool
+	wildcards  map[string]bool
+	exceptions map[string]bool
+}
+
+var (
+	loadPublicSuffixRulesOnce sync.Once
+	publicSuffixRuleSet       *publicSuffixRules
+)
+
+func loadPublicSuffixRules() *publicSuffixRules {
+	loadPublicSuffixRulesOnce.Do(func() {
+		publicSuffixRuleSet = parsePublicSuffixList(publicSuffixList)
+	})
+	return publicSuffixRuleSet
+}
+
+func parsePublicSuffixList(list string) *publicSuffixRules {
+	rules := &publicSuffixRules{
+		exact:      make(map[string]bool),
+		wildcards:  make(map[string]bool),
+		exceptions: make(map[string]bool),
+	}
+
+	for _, line := range strings.Split(list, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "//") {
+			continue
+		}
+
+		rule, set := fields[0], rules.exact
+		if strings.HasPrefix(rule, "!") {
+			rule, set = rule[1:], rules.exceptions
+		} else if strings.HasPrefix(rule, "*.") {
+			rule, set = rule[2:], rules.wildcards
+		}
+
+		// realm hosts are in the ASCII compatible encoding
+		if normalized, err := gopenid.NormalizeHost(rule); err == nil {
+			set[normalized] = true
+		}
+	}
+
+	return rules
+}
+
+// isPublicSuffix reports whether host, normalized by gopenid.NormalizeHost, is a public suffix.
+// Hosts of a single label are public suffixes by the implicit "*" rule.
+func isPublicSuffix(host string) bool {
+	rules := loadPublicSuffixRules()
+	if rules.exceptions[host] {
+		return false
+	} else if rules.exact[host] {
+		return true
+	}
+
+	idx := strings.IndexByte(host, '.')
+	if idx < 0 {
+		return true
+	}
+	return rules.wildcards[host[idx+1:]]
+}
+
+// hasPublicSuffixChildren reports whether host, normalized by gopenid.NormalizeHost, has
+// public suffixes right under it by a wildcard rule, such as kawasaki.jp by *.kawasaki.jp.
+func hasPublicSuffixChildren(host string) bool {
+	return loadPublicSuffixRules().wildcards[host]
+}
@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublicSuffix(t *testing.T) {
+	for host, expected := range map[string]bool{
+		"com":            true,
+		"co.uk":          true,
+		"example.co.uk":  false,
+		"github.io":      true,
+		"example.com":    false,
+		"local":          true,
+		"xn--fiqs8s":     true,
+		"anything.ck":    true,
+		"www.ck":         false,
+		"www.example.ck": false,
+	} {
+		assert.Equal(t, isPublicSuffix(host), expected, host)
+	}
+}
+
+func TestPublicSuffixRealmPolicy(t *testing.T) {
+	for _, testCase := range []struct {
+		policy   *PublicSuffixRealmPolicy
+		realm    string
+		expected error
+	}{
+		{&PublicSuffixRealmPolicy{}, "http://*.example.com/", nil},
+		{&PublicSuffixRealmPolicy{}, "http://*.com/", ErrPublicSuffixRealm},
+		{&PublicSuffixRealmPolicy{}, "http://*.co.uk/", ErrPublicSuffixRealm},
+		{&PublicSuffixRealmPolicy{}, "http://*.中国/", ErrPublicSuffixRealm},
+		{&PublicSuffixRealmPolicy{}, "http://*.kawasaki.jp/", ErrPublicSuffixRealm},
+		{&PublicSuffixRealmPolicy{}, "http://*.compute.amazonaws.com/", ErrPublicSuffixRealm},
+		{&PublicSuffixRealmPolicy{}, "http://*.city.kawasaki.jp/", nil},
+		{&PublicSuffixRealmPolicy{}, "http://com/", nil},
+		{&PublicSuffixRealmPolicy{}, "http://192.0.2.1/", nil},
+		{&PublicSuffixRealmPolicy{}, "http://*.192.0.2.1/", ErrIPLiteralRealm},
+		{&PublicSuffixRealmPolicy{RejectIPLiterals: true}, "http://192.0.2.1/", ErrIPLiteralRealm},
+		{&PublicSuffixRealmPolicy{RejectIPLiterals: true}, "http://[::1]:8080/", ErrIPLiteralRealm},
+		{&PublicSuffixRealmPolicy{RequireHTTPS: true}, "http://example.com/", ErrInsecureRealm},
+		{&PublicSuffixRealmPolicy{RequireHTTPS: true}, "https://example.com/", nil},
+	} {
+		realm, err := ParseRealm(testCase.realm)
+		if assert.Nil(t, err, testCase.realm) {
+			assert.Equal(t, testCase.policy.CheckRealm(realm), testCase.expected, testCase.realm)
+		}
+	}
+}
+
+func TestCheckIDRequestRealmPolicy(t *testing.T) {
+	for _, testCase := range []struct {
+		policy   RealmPolicy
+		realm    string
+		expected error
+	}{
+		{nil, "http://*.example.com/", nil},
+		{nil, "http://*.com/", ErrPublicSuffixRealm},
+		{&PublicSuffixRealmPolicy{RequireHTTPS: true}, "http://*.example.com/", ErrInsecureRealm},
+	} {
+		msg, err := gopenid.MessageFromQuery(url.Values{
+			"openid.ns":    []string{gopenid.NsOpenID20.String()},
+			"openid.mode":  []string{"checkid_setup"},
+			"openid.realm": []string{testCase.realm},
+		})
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		_, err = checkIDRequestFromMessage("GET", msg, testCase.policy)
+		assert.Equal(t, err, testCase.expected, testCase.realm)
+	}
+}
@@ -2,15 +2,35 @@ package provider
 
 import (
 	"errors"
+	"net"
 	"net/url"
 	"strconv"
 	"strings"
+
+	"github.com/GehirnInc/GOpenID"
 )
 
 var (
 	ErrMalformedRealm = errors.New("malformed realm")
 )
 
+// RealmPolicy decides whether relying parties may request assertions for a realm.
+type RealmPolicy interface {
+	CheckRealm(realm Realm) error
+}
+
+// RealmPolicyFunc is an adapter to use ordinary functions as RealmPolicy.
+type RealmPolicyFunc func(realm Realm) error
+
+// CheckRealm calls f(realm).
+func (f RealmPolicyFunc) CheckRealm(realm Realm) error {
+	return f(realm)
+}
+
+// Realm is a pattern of URLs a relying party asks assertions for.
+//
+// Host is lowercased and in the ASCII compatible encoding, without brackets for IPv6 literals.
+// For wildcard realms, Host has the leading dot of the wildcard, e.g. ".example.com" for *.example.com.
 type Realm struct {
 	Scheme   string
 	Host     string
@@ -21,68 +41,137 @@ type Realm struct {
 }
 
 func ParseRealm(rawurl string) (realm Realm, err error) {
-	// var parsed *net.URL
 	parsed, err := url.Parse(rawurl)
 	if err != nil {
 		err = ErrMalformedRealm
 		return
 	}
 
-	if !(parsed.Scheme == "http" || parsed.Scheme == "https") {
+	scheme := strings.ToLower(parsed.Scheme)
+	if !(scheme == "http" || scheme == "https") {
+		err = ErrMalformedRealm
+		return
+	} else if parsed.Fragment != "" || parsed.User != nil || parsed.Opaque != "" {
 		err = ErrMalformedRealm
 		return
 	}
 
 	var (
-		host     = parsed.Host
-		port     string
+		host     = parsed.Hostname()
+		port     = parsed.Port()
 		wildcard bool
 	)
-	if idx := strings.Index(host, ":"); idx > -1 {
-		port = host[idx+1:]
-		host = host[0:idx]
-		if portInt, err := strconv.Atoi(port); err != nil {
+
+	if port != "" {
+		if portInt, convErr := strconv.Atoi(port); convErr != nil || portInt < 1 || portInt > 65535 {
 			err = ErrMalformedRealm
-		} else if (parsed.Scheme == "http" && portInt == 80) || (parsed.Scheme == "https" && portInt == 443) {
+			return
+		} else if (scheme == "http" && portInt == 80) || (scheme == "https" && portInt == 443) {
 			port = ""
+		} else {
+			port = strconv.Itoa(portInt)
 		}
+	} else if strings.HasSuffix(parsed.Host, ":") {
+		err = ErrMalformedRealm
+		return
 	}
 
-	if idx := strings.Index(host, "*"); idx == 0 {
-		if len(host) < 2 || host[1] != '.' {
+	if strings.Contains(host, ":") {
+		// IPv6 literal, which url.Parse accepts only in brackets
+		ip := net.ParseIP(host)
+		if ip == nil {
 			err = ErrMalformedRealm
 			return
-		} else if strings.Count(host, "*") > 1 {
+		}
+		host = ip.String()
+	} else {
+		if idx := strings.Index(host, "*"); idx == 0 {
+			if len(host) < 3 || host[1] != '.' || strings.Count(host, "*") > 1 {
+				err = ErrMalformedRealm
+				return
+			}
+			host = host[1:]
+			wildcard = true
+		} else if idx > 0 {
 			err = ErrMalformedRealm
 			return
 		}
-		host = host[1:]
-		wildcard = true
-	} else if idx > 0 {
-		err = ErrMalformedRealm
-		return
+
+		if host, err = normalizeRealmHost(host, wildcard); err != nil {
+			return
+		}
 	}
 
-	if parsed.Fragment != "" {
-		err = ErrMalformedRealm
-		return
+	path := parsed.Path
+	if path == "" {
+		path = "/"
 	}
 
-	if queryLen := len(parsed.RawQuery); queryLen > 0 && parsed.RawQuery[queryLen-1] == '&' {
-		parsed.RawQuery = parsed.RawQuery[:queryLen-1]
+	rawQuery := parsed.RawQuery
+	if queryLen := len(rawQuery); queryLen > 0 && rawQuery[queryLen-1] == '&' {
+		rawQuery = rawQuery[:queryLen-1]
 	}
 
 	realm = Realm{
-		Scheme:   parsed.Scheme,
+		Scheme:   scheme,
 		Host:     host,
 		Port:     port,
-		Path:     parsed.Path,
-		RawQuery: parsed.RawQuery,
+		Path:     path,
+		RawQuery: rawQuery,
 		Wildcard: wildcard,
 	}
 	return
 }
 
+// normalizeRealmHost converts host to the ASCII compatible encoding, so that Unicode and
+// Punycode forms of a host name are equal.
+func normalizeRealmHost(host string, wildcard bool) (string, error) {
+	if wildcard {
+		host = host[1:]
+	}
+
+	normalized, err := gopenid.NormalizeHost(host)
+	if err != nil {
+		return "", ErrMalformedRealm
+	}
+
+	// example.com. is example.com
+	if normalized = strings.TrimSuffix(normalized, "."); normalized == "" {
+		return "", ErrMalformedRealm
+	}
+
+	if wildcard {
+		normalized = "." + normalized
+	}
+	return normalized, nil
+}
+
+// String returns the canonical form of realm.
+func (realm Realm) String() string {
+	var b strings.Builder
+	b.WriteString(realm.Scheme)
+	b.WriteString("://")
+
+	if realm.Wildcard {
+		b.WriteString("*")
+	}
+	if strings.Contains(realm.Host, ":") {
+		b.WriteString("[" + realm.Host + "]")
+	} else {
+		b.WriteString(realm.Host)
+	}
+	if realm.Port != "" {
+		b.WriteString(":" + realm.Port)
+	}
+
+	b.WriteString((&url.URL{Path: realm.Path}).EscapedPath())
+	if realm.RawQuery != "" {
+		b.WriteString("?" + realm.RawQuery)
+	}
+
+	return b.String()
+}
+
 func (realm *Realm) Validate(rawurl string) bool {
 	if idx := strings.Index(rawurl, "#"); idx > -1 {
 		rawurl = rawurl[0:idx]
@@ -102,7 +191,8 @@ func (realm *Realm) Validate(rawurl string) bool {
 	if parsed.Wildcard {
 		return false
 	} else if realm.Wildcard {
-		if !strings.HasSuffix(parsed.Host, realm.Host[1:]) {
+		// *.example.com matches example.com and its subdomains, but not notexample.com
+		if parsed.Host != realm.Host[1:] && !strings.HasSuffix(parsed.Host, realm.Host) {
 			return false
 		}
 	} else if realm.Host != parsed.Host {
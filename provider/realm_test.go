@@ -79,6 +79,78 @@ var (
 				Wildcard: true,
 			},
 		},
+		RealmTestCase{
+			RawURL: "http://[::1]:8080/",
+			expected: Realm{
+				Scheme:   "http",
+				Host:     "::1",
+				Port:     "8080",
+				Path:     "/",
+				RawQuery: "",
+				Wildcard: false,
+			},
+		},
+		RealmTestCase{
+			RawURL: "https://[2001:DB8::1]:443/",
+			expected: Realm{
+				Scheme:   "https",
+				Host:     "2001:db8::1",
+				Port:     "",
+				Path:     "/",
+				RawQuery: "",
+				Wildcard: false,
+			},
+		},
+		RealmTestCase{
+			RawURL: "http://*.例え.jp",
+			expected: Realm{
+				Scheme:   "http",
+				Host:     ".xn--r8jz45g.jp",
+				Port:     "",
+				Path:     "/",
+				RawQuery: "",
+				Wildcard: true,
+			},
+		},
+		RealmTestCase{
+			RawURL: "HTTP://Example.COM./",
+			expected: Realm{
+				Scheme:   "http",
+				Host:     "example.com",
+				Port:     "",
+				Path:     "/",
+				RawQuery: "",
+				Wildcard: false,
+			},
+		},
+		RealmTestCase{
+			RawURL: "http://example.com:http/",
+			err:    ErrMalformedRealm,
+		},
+		RealmTestCase{
+			RawURL: "http://example.com:0/",
+			err:    ErrMalformedRealm,
+		},
+		RealmTestCase{
+			RawURL: "http://example.com:65536/",
+			err:    ErrMalformedRealm,
+		},
+		RealmTestCase{
+			RawURL: "http://example.com:/",
+			err:    ErrMalformedRealm,
+		},
+		RealmTestCase{
+			RawURL: "http://[::1/",
+			err:    ErrMalformedRealm,
+		},
+		RealmTestCase{
+			RawURL: "http://*.[::1]/",
+			err:    ErrMalformedRealm,
+		},
+		RealmTestCase{
+			RawURL: "http://*./",
+			err:    ErrMalformedRealm,
+		},
 		RealmTestCase{
 			RawURL: "ftp://example.com/",
 			err:    ErrMalformedRealm,
@@ -146,4 +218,48 @@ func TestRealm(t *testing.T) {
 	assert.True(t, realm.Validate("http://example.com/?foo=bar#baz"))
 	assert.True(t, realm.Validate("http://example.com/?foo=bar&hoge=fuga"))
 	assert.False(t, realm.Validate("http://example.com/?hoge=fuga&foo=bar"))
+
+	// wildcard
+	realm, _ = ParseRealm("http://*.example.com/")
+	assert.True(t, realm.Validate("http://example.com/"))
+	assert.True(t, realm.Validate("http://www.example.com/"))
+	assert.True(t, realm.Validate("http://a.b.example.com/"))
+	assert.False(t, realm.Validate("http://notexample.com/"))
+	assert.False(t, realm.Validate("http://example.com.net/"))
+
+	// IPv6
+	realm, _ = ParseRealm("http://[::1]:8080/")
+	assert.True(t, realm.Validate("http://[0:0::1]:8080/return"))
+	assert.False(t, realm.Validate("http://[::1]/return"))
+	assert.False(t, realm.Validate("http://[::2]:8080/return"))
+
+	// internationalized host names
+	realm, _ = ParseRealm("http://例え.jp/")
+	assert.True(t, realm.Validate("http://xn--r8jz45g.jp/return"))
+	assert.True(t, realm.Validate("http://例え.JP/return"))
+	realm, _ = ParseRealm("http://*.xn--r8jz45g.jp/")
+	assert.True(t, realm.Validate("http://www.例え.jp/return"))
+}
+
+func TestRealmString(t *testing.T) {
+	for rawurl, expected := range map[string]string{
+		"http://example.com":              "http://example.com/",
+		"HTTP://Example.com:80/":          "http://example.com/",
+		"https://example.com:8443/a%20b/": "https://example.com:8443/a%20b/",
+		"http://*.example.com/?foo=bar&":  "http://*.example.com/?foo=bar",
+		"http://[::1]:8080/":              "http://[::1]:8080/",
+		"http://*.例え.jp/":                 "http://*.xn--r8jz45g.jp/",
+		"http://example.com:08080/dir/":   "http://example.com:8080/dir/",
+	} {
+		realm, err := ParseRealm(rawurl)
+		if assert.Nil(t, err, rawurl) {
+			assert.Equal(t, realm.String(), expected)
+
+			// canonical forms parse to the same realm
+			reparsed, err := ParseRealm(realm.String())
+			if assert.Nil(t, err) {
+				assert.Equal(t, reparsed, realm)
+			}
+		}
+	}
 }
@@ -13,6 +13,9 @@ var (
 	ErrInvalidCheckIDRequest             = errors.New("invalid checkid_* request")
 	ErrInvalidCheckAuthenticationRequest = errors.New("invalid checkid_authentication request")
 	ErrInvalidAssociateRequest           = errors.New("invalid associate request")
+	ErrDHModulusTooLarge                 = errors.New("dh_modulus too large")
+	ErrDHGenTooLarge                     = errors.New("dh_gen too large")
+	ErrDHConsumerPublicTooLarge          = errors.New("dh_consumer_public too large")
 
 	DefaultDhGen     = big.NewInt(2)
 	DefaultDhModulus = new(big.Int).SetBytes([]byte{
@@ -35,18 +38,60 @@ var (
 type Request interface {
 	GetMode() string
 	GetNamespace() gopenid.NamespaceURI
-	GetMessage() gopenid.Message
+	GetMessage() *gopenid.Message
 }
 
-func RequestFromMessage(method string, msg gopenid.Message) (Request, error) {
+// CheckIDRequest is a checkid_setup or checkid_immediate request.
+type CheckIDRequest interface {
+	Request
+
+	Realm() Realm
+	ReturnTo() string
+	ClaimedID() string
+	Identity() string
+	// IsImmediate reports whether the request is checkid_immediate, which must be answered
+	// without interacting with the user.
+	IsImmediate() bool
+	// IsIdentifierSelect reports whether the relying party let the OP choose the identifier.
+	IsIdentifierSelect() bool
+	AssocHandle() string
+	// Extensions returns arguments of requested extensions by their namespace.
+	Extensions() map[gopenid.NamespaceURI]map[string]string
+}
+
+// AssociateRequest is an associate request.
+type AssociateRequest interface {
+	Request
+
+	AssocType() gopenid.AssocType
+	SessionType() gopenid.SessionType
+}
+
+// CheckAuthenticationRequest is a check_authentication request.
+type CheckAuthenticationRequest interface {
+	Request
+
+	AssocHandle() string
+}
+
+// RequestFromMessage returns Request for msg. Realms of checkid requests are checked by
+// PublicSuffixRealmPolicy with default options, and Diffie-Hellman values of associate requests
+// are limited to DefaultMaxDHModulusBits.
+func RequestFromMessage(method string, msg *gopenid.Message) (Request, error) {
+	return requestFromMessage(method, msg, nil, DefaultMaxDHModulusBits)
+}
+
+// requestFromMessage returns Request for msg, checking realms of checkid requests also by policy if not nil,
+// and limiting Diffie-Hellman values of associate requests to maxDHModulusBits if positive.
+func requestFromMessage(method string, msg *gopenid.Message, policy RealmPolicy, maxDHModulusBits int) (Request, error) {
 	mode, _ := msg.GetArg(gopenid.NewMessageKey(msg.GetOpenIDNamespace(), "mode"))
 	switch mode {
 	case "checkid_immediate":
-		return checkIDRequestFromMessage(method, msg)
+		return checkIDRequestFromMessage(method, msg, policy)
 	case "checkid_setup":
-		return checkIDRequestFromMessage(method, msg)
+		return checkIDRequestFromMessage(method, msg, policy)
 	case "associate":
-		return associateRequestFromMessage(method, msg)
+		return associateRequestFromMessage(method, msg, maxDHModulusBits)
 	case "check_authentication":
 		return checkAuthenticationRequestFromMessage(method, msg)
 	default:
@@ -55,7 +100,7 @@ func RequestFromMessage(method string, msg gopenid.Message) (Request, error) {
 }
 
 type checkIDRequest struct {
-	message gopenid.Message
+	message *gopenid.Message
 
 	mode        gopenid.MessageValue
 	claimedId   gopenid.MessageValue
@@ -63,9 +108,10 @@ type checkIDRequest struct {
 	assocHandle gopenid.MessageValue
 	returnTo    gopenid.MessageValue
 	realm       gopenid.MessageValue
+	parsedRealm Realm
 }
 
-func checkIDRequestFromMessage(method string, msg gopenid.Message) (req *checkIDRequest, err error) {
+func checkIDRequestFromMessage(method string, msg *gopenid.Message, policy RealmPolicy) (req *checkIDRequest, err error) {
 	ns := msg.GetOpenIDNamespace()
 	mode, _ := msg.GetArg(gopenid.NewMessageKey(ns, "mode"))
 
@@ -92,21 +138,22 @@ func checkIDRequestFromMessage(method string, msg gopenid.Message) (req *checkID
 		// openid.realm MUST be sent if openid.return_to is omitted
 		err = ErrInvalidCheckIDRequest
 		return
-	} else if realm != "" {
-		var parsed Realm
-		parsed, err = ParseRealm(realm.String())
-		if err != nil {
-			return
-		}
-
-		if returnTo != "" && !parsed.Validate(returnTo.String()) {
-			err = ErrInvalidCheckIDRequest
-			return
-		}
-	} else {
+	} else if realm == "" {
 		realm = returnTo
 	}
 
+	parsedRealm, err := ParseRealm(realm.String())
+	if err != nil {
+		return
+	} else if returnTo != "" && !parsedRealm.Validate(returnTo.String()) {
+		err = ErrInvalidCheckIDRequest
+		return
+	}
+
+	if err = checkRealm(parsedRealm, policy); err != nil {
+		return
+	}
+
 	req = &checkIDRequest{
 		message:     msg,
 		mode:        mode,
@@ -115,6 +162,7 @@ func checkIDRequestFromMessage(method string, msg gopenid.Message) (req *checkID
 		assocHandle: assocHandle,
 		returnTo:    returnTo,
 		realm:       realm,
+		parsedRealm: parsedRealm,
 	}
 	return
 }
@@ -127,12 +175,53 @@ func (req *checkIDRequest) GetMode() string {
 	return req.mode.String()
 }
 
-func (req *checkIDRequest) GetMessage() gopenid.Message {
+func (req *checkIDRequest) GetMessage() *gopenid.Message {
 	return req.message
 }
 
+func (req *checkIDRequest) Realm() Realm {
+	return req.parsedRealm
+}
+
+func (req *checkIDRequest) ReturnTo() string {
+	return req.returnTo.String()
+}
+
+func (req *checkIDRequest) ClaimedID() string {
+	return req.claimedId.String()
+}
+
+func (req *checkIDRequest) Identity() string {
+	return req.identity.String()
+}
+
+func (req *checkIDRequest) IsImmediate() bool {
+	return req.mode == "checkid_immediate"
+}
+
+func (req *checkIDRequest) IsIdentifierSelect() bool {
+	return req.identity.String() == gopenid.NsIdentifierSelect.String()
+}
+
+func (req *checkIDRequest) AssocHandle() string {
+	return req.assocHandle.String()
+}
+
+func (req *checkIDRequest) Extensions() map[gopenid.NamespaceURI]map[string]string {
+	extensions := make(map[gopenid.NamespaceURI]map[string]string)
+	for _, nsuri := range req.message.GetNamespaceURIs() {
+		args := make(map[string]string)
+		for key, value := range req.message.GetArgs(nsuri) {
+			args[key.GetKey()] = value.String()
+		}
+		extensions[nsuri] = args
+	}
+
+	return extensions
+}
+
 type checkAuthenticationRequest struct {
-	message gopenid.Message
+	message *gopenid.Message
 
 	mode          gopenid.MessageValue
 	assocHandle   gopenid.MessageValue
@@ -141,7 +230,7 @@ type checkAuthenticationRequest struct {
 	responseNonce gopenid.MessageValue
 }
 
-func checkAuthenticationRequestFromMessage(method string, msg gopenid.Message) (req *checkAuthenticationRequest, err error) {
+func checkAuthenticationRequestFromMessage(method string, msg *gopenid.Message) (req *checkAuthenticationRequest, err error) {
 	if method != "POST" {
 		err = ErrMethodNotAllowed
 		return
@@ -200,12 +289,16 @@ func (req *checkAuthenticationRequest) GetNamespace() gopenid.NamespaceURI {
 	return req.message.GetOpenIDNamespace()
 }
 
-func (req *checkAuthenticationRequest) GetMessage() gopenid.Message {
+func (req *checkAuthenticationRequest) GetMessage() *gopenid.Message {
 	return req.message
 }
 
+func (req *checkAuthenticationRequest) AssocHandle() string {
+	return req.assocHandle.String()
+}
+
 type associateRequest struct {
-	message gopenid.Message
+	message *gopenid.Message
 	err     error
 
 	mode        gopenid.MessageValue
@@ -216,7 +309,7 @@ type associateRequest struct {
 	dhConsumerPublic dh.PublicKey
 }
 
-func associateRequestFromMessage(method string, msg gopenid.Message) (req *associateRequest, err error) {
+func associateRequestFromMessage(method string, msg *gopenid.Message, maxDHModulusBits int) (req *associateRequest, err error) {
 	if method != "POST" {
 		err = ErrMethodNotAllowed
 		return
@@ -248,10 +341,18 @@ func associateRequestFromMessage(method string, msg gopenid.Message) (req *assoc
 		)
 		PBase64, _ := msg.GetArg(gopenid.NewMessageKey(ns, "dh_modulus"))
 		if PBase64 != "" {
+			if exceedsDHBits(len(PBase64), maxDHModulusBits) {
+				err = ErrDHModulusTooLarge
+				return
+			}
+
 			P, err = gopenid.Base64ToInt(PBase64.Bytes())
 			if err != nil {
 				err = ErrInvalidAssociateRequest
 				return
+			} else if maxDHModulusBits > 0 && P.BitLen() > maxDHModulusBits {
+				err = ErrDHModulusTooLarge
+				return
 			}
 		} else {
 			P = DefaultDhModulus
@@ -259,10 +360,18 @@ func associateRequestFromMessage(method string, msg gopenid.Message) (req *assoc
 
 		GBase64, _ := msg.GetArg(gopenid.NewMessageKey(ns, "dh_gen"))
 		if GBase64 != "" {
+			if exceedsDHBits(len(GBase64), P.BitLen()) {
+				err = ErrDHGenTooLarge
+				return
+			}
+
 			G, err = gopenid.Base64ToInt(GBase64.Bytes())
 			if err != nil {
 				err = ErrInvalidAssociateRequest
 				return
+			} else if G.Cmp(P) >= 0 {
+				err = ErrDHGenTooLarge
+				return
 			}
 		} else {
 			G = DefaultDhGen
@@ -276,10 +385,18 @@ func associateRequestFromMessage(method string, msg gopenid.Message) (req *assoc
 			Y *big.Int
 		)
 		YBase64, _ := msg.GetArg(gopenid.NewMessageKey(ns, "dh_consumer_public"))
+		if exceedsDHBits(len(YBase64), P.BitLen()) {
+			err = ErrDHConsumerPublicTooLarge
+			return
+		}
+
 		Y, err = gopenid.Base64ToInt(YBase64.Bytes())
 		if err != nil {
 			err = ErrInvalidAssociateRequest
 			return
+		} else if Y.Cmp(P) >= 0 {
+			err = ErrDHConsumerPublicTooLarge
+			return
 		}
 		req.dhConsumerPublic = dh.PublicKey{
 			Y: Y,
@@ -289,6 +406,18 @@ func associateRequestFromMessage(method string, msg gopenid.Message) (req *assoc
 	return
 }
 
+// exceedsDHBits reports whether an integer encoded in base64 of encodedLen bytes cannot fit in bits,
+// so that it can be rejected before being decoded. It is false if bits is not positive.
+func exceedsDHBits(encodedLen, bits int) bool {
+	if bits <= 0 {
+		return false
+	}
+
+	// btwoc adds a leading zero byte to integers with the most significant bit set
+	maxBytes := bits/8 + 2
+	return encodedLen > (maxBytes+2)/3*4
+}
+
 func (req *associateRequest) GetMode() string {
 	return req.mode.String()
 }
@@ -297,6 +426,14 @@ func (req *associateRequest) GetNamespace() gopenid.NamespaceURI {
 	return req.message.GetOpenIDNamespace()
 }
 
-func (req *associateRequest) GetMessage() gopenid.Message {
+func (req *associateRequest) GetMessage() *gopenid.Message {
 	return req.message
 }
+
+func (req *associateRequest) AssocType() gopenid.AssocType {
+	return req.assocType
+}
+
+func (req *associateRequest) SessionType() gopenid.SessionType {
+	return req.sessionType
+}
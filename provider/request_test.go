@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/GehirnInc/GOpenID"
 	"github.com/stretchr/testify/assert"
+	"math/big"
 	"net/url"
 	"testing"
 )
@@ -159,10 +160,10 @@ func TestCheckIDRequest(t *testing.T) {
 	for index, testCase := range checkidRequestCases {
 		msg, err := gopenid.MessageFromQuery(testCase.request)
 		if !assert.Nil(t, err) {
-			t.Log("invalid request data in index %d", index)
+			t.Logf("invalid request data in index %d", index)
 		}
 
-		req, err := checkIDRequestFromMessage("GET", msg)
+		req, err := checkIDRequestFromMessage("GET", msg, nil)
 		if !assert.Nil(t, err) {
 			t.Logf("invalid request data in index %d, %v\n", index, err)
 			continue
@@ -181,3 +182,119 @@ func TestCheckIDRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestRequestGetters(t *testing.T) {
+	msg, err := gopenid.MessageFromQuery(url.Values{
+		"openid.ns":           []string{gopenid.NsOpenID20.String()},
+		"openid.mode":         []string{"checkid_immediate"},
+		"openid.identity":     []string{gopenid.NsIdentifierSelect.String()},
+		"openid.claimed_id":   []string{gopenid.NsIdentifierSelect.String()},
+		"openid.assoc_handle": []string{"handle"},
+		"openid.realm":        []string{"http://*.example.com/"},
+		"openid.return_to":    []string{"http://rp.example.com/return"},
+		"openid.ns.ext":       []string{"http://example.com/ext"},
+		"openid.ext.name":     []string{"required"},
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	req, err := RequestFromMessage("GET", msg)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	if checkID, ok := req.(CheckIDRequest); assert.True(t, ok) {
+		assert.Equal(t, checkID.Realm().String(), "http://*.example.com/")
+		assert.Equal(t, checkID.ReturnTo(), "http://rp.example.com/return")
+		assert.Equal(t, checkID.ClaimedID(), gopenid.NsIdentifierSelect.String())
+		assert.Equal(t, checkID.Identity(), gopenid.NsIdentifierSelect.String())
+		assert.True(t, checkID.IsImmediate())
+		assert.True(t, checkID.IsIdentifierSelect())
+		assert.Equal(t, checkID.AssocHandle(), "handle")
+		assert.Equal(t, checkID.Extensions(), map[gopenid.NamespaceURI]map[string]string{
+			"http://example.com/ext": map[string]string{"name": "required"},
+		})
+	}
+
+	msg, err = gopenid.MessageFromQuery(url.Values{
+		"openid.ns":           []string{gopenid.NsOpenID20.String()},
+		"openid.mode":         []string{"associate"},
+		"openid.assoc_type":   []string{"HMAC-SHA256"},
+		"openid.session_type": []string{"no-encryption"},
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	req, err = RequestFromMessage("POST", msg)
+	if assert.Nil(t, err) {
+		if associate, ok := req.(AssociateRequest); assert.True(t, ok) {
+			assocType, sessionType := associate.AssocType(), associate.SessionType()
+			assert.Equal(t, assocType.Name(), "HMAC-SHA256")
+			assert.Equal(t, sessionType.Name(), "no-encryption")
+		}
+	}
+
+	msg, err = gopenid.MessageFromQuery(url.Values{
+		"openid.ns":             []string{gopenid.NsOpenID20.String()},
+		"openid.mode":           []string{"check_authentication"},
+		"openid.assoc_handle":   []string{"handle"},
+		"openid.signed":         []string{"assoc_handle"},
+		"openid.sig":            []string{"c2ln"},
+		"openid.response_nonce": []string{"2014-01-01T00:00:00Zabc"},
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	req, err = RequestFromMessage("POST", msg)
+	if assert.Nil(t, err) {
+		if checkAuth, ok := req.(CheckAuthenticationRequest); assert.True(t, ok) {
+			assert.Equal(t, checkAuth.AssocHandle(), "handle")
+		}
+	}
+}
+
+func TestAssociateRequestDHLimits(t *testing.T) {
+	large := new(big.Int).Lsh(big.NewInt(1), 2048)
+	large.Sub(large, big.NewInt(1))
+	small := new(big.Int).Lsh(big.NewInt(1), 1000)
+
+	for _, testCase := range []struct {
+		modulus *big.Int
+		gen     *big.Int
+		public  *big.Int
+		err     error
+	}{
+		{nil, nil, small, nil},
+		{large, nil, small, nil},
+		{new(big.Int).Lsh(large, 1), nil, small, ErrDHModulusTooLarge},
+		{new(big.Int).Lsh(large, 4096), nil, small, ErrDHModulusTooLarge},
+		{nil, DefaultDhModulus, small, ErrDHGenTooLarge},
+		{nil, nil, DefaultDhModulus, ErrDHConsumerPublicTooLarge},
+		{nil, nil, large, ErrDHConsumerPublicTooLarge},
+	} {
+		query := url.Values{
+			"openid.ns":                 []string{gopenid.NsOpenID20.String()},
+			"openid.mode":               []string{"associate"},
+			"openid.assoc_type":         []string{"HMAC-SHA1"},
+			"openid.session_type":       []string{"DH-SHA1"},
+			"openid.dh_consumer_public": []string{string(gopenid.IntToBase64(testCase.public))},
+		}
+		if testCase.modulus != nil {
+			query.Set("openid.dh_modulus", string(gopenid.IntToBase64(testCase.modulus)))
+		}
+		if testCase.gen != nil {
+			query.Set("openid.dh_gen", string(gopenid.IntToBase64(testCase.gen)))
+		}
+
+		msg, err := gopenid.MessageFromQueryWithLimits(query, gopenid.MessageLimits{})
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		_, err = requestFromMessage("POST", msg, nil, 2048)
+		assert.Equal(t, err, testCase.err)
+	}
+}
@@ -2,6 +2,7 @@ package provider
 
 import (
 	"github.com/GehirnInc/GOpenID"
+	"net/http"
 	"net/url"
 )
 
@@ -11,15 +12,51 @@ type Response interface {
 	GetRedirectTo() string
 	GetBody() []byte
 	GetContentType() string
+
+	// StatusCode returns the HTTP status code of the response.
+	StatusCode() int
+	// Header returns HTTP headers sent besides Content-Type and Location.
+	// Changes to it are sent by WriteTo.
+	Header() http.Header
+	// WriteTo writes the response to w answering r.
+	WriteTo(w http.ResponseWriter, r *http.Request)
+}
+
+// writeResponse writes res to w, as Response.WriteTo of types in this package does.
+func writeResponse(res Response, w http.ResponseWriter, r *http.Request) {
+	for key, values := range res.Header() {
+		w.Header()[key] = values
+	}
+
+	if res.NeedsRedirect() {
+		http.Redirect(w, r, res.GetRedirectTo(), res.StatusCode())
+		return
+	}
+
+	if contentType := res.GetContentType(); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(res.StatusCode())
+	w.Write(res.GetBody())
+}
+
+// redirectStatusCode returns the status code redirecting permanently or not.
+func redirectStatusCode(isPermanently bool) int {
+	if isPermanently {
+		return http.StatusMovedPermanently
+	}
+	return http.StatusFound
 }
 
 type openIDResponse struct {
 	request       Request
-	message       gopenid.Message
+	message       *gopenid.Message
 	needsRedirect bool
 	isPermanently bool
 	contentType   string
 	returnTo      string
+	isError       bool
+	header        http.Header
 }
 
 func newOpenIDResponse(req Request) *openIDResponse {
@@ -58,7 +95,7 @@ func (res *openIDResponse) GetArg(key gopenid.MessageKey) (gopenid.MessageValue,
 	return res.message.GetArg(key)
 }
 
-func (res *openIDResponse) GetMessage() gopenid.Message {
+func (res *openIDResponse) GetMessage() *gopenid.Message {
 	return res.message
 }
 
@@ -74,11 +111,16 @@ func (res *openIDResponse) GetRedirectTo() string {
 	redirectTo, _ := url.Parse(res.returnTo)
 	query := redirectTo.Query()
 
-	for k, v := range res.message.ToQuery() {
-		query[k] = v
+	// parameters of return_to come first, unless the message overrides them
+	for k := range res.message.ToQuery() {
+		query.Del(k)
 	}
 
-	redirectTo.RawQuery = query.Encode()
+	if len(query) > 0 {
+		redirectTo.RawQuery = query.Encode() + "&" + res.message.Encode()
+	} else {
+		redirectTo.RawQuery = res.message.Encode()
+	}
 	return redirectTo.String()
 }
 
@@ -91,13 +133,36 @@ func (res *openIDResponse) GetContentType() string {
 	return res.contentType
 }
 
+// StatusCode returns 400 for error responses, which are sent back directly or shown to the user.
+func (res *openIDResponse) StatusCode() int {
+	if res.needsRedirect {
+		return redirectStatusCode(res.isPermanently)
+	} else if res.isError {
+		return http.StatusBadRequest
+	}
+	return http.StatusOK
+}
+
+func (res *openIDResponse) Header() http.Header {
+	if res.header == nil {
+		res.header = make(http.Header)
+	}
+	return res.header
+}
+
+func (res *openIDResponse) WriteTo(w http.ResponseWriter, r *http.Request) {
+	writeResponse(res, w, r)
+}
+
 type yadisResponse struct {
-	et *gopenid.XRDSDocument
+	et     *gopenid.XRDSDocument
+	header http.Header
 }
 
 func newYadisResponse(et *gopenid.XRDSDocument) *yadisResponse {
 	return &yadisResponse{
-		et: et,
+		et:     et,
+		header: make(http.Header),
 	}
 }
 
@@ -121,3 +186,59 @@ func (res *yadisResponse) GetBody() (b []byte) {
 func (res *yadisResponse) GetContentType() string {
 	return "application/xrds+xml"
 }
+
+func (res *yadisResponse) StatusCode() int {
+	return http.StatusOK
+}
+
+func (res *yadisResponse) Header() http.Header {
+	return res.header
+}
+
+func (res *yadisResponse) WriteTo(w http.ResponseWriter, r *http.Request) {
+	writeResponse(res, w, r)
+}
+
+type redirectResponse struct {
+	redirectTo string
+	header     http.Header
+}
+
+func newRedirectResponse(redirectTo string) *redirectResponse {
+	return &redirectResponse{
+		redirectTo: redirectTo,
+		header:     make(http.Header),
+	}
+}
+
+func (res *redirectResponse) NeedsRedirect() bool {
+	return true
+}
+
+func (res *redirectResponse) IsPermanently() bool {
+	return false
+}
+
+func (res *redirectResponse) GetRedirectTo() string {
+	return res.redirectTo
+}
+
+func (res *redirectResponse) GetBody() []byte {
+	return nil
+}
+
+func (res *redirectResponse) GetContentType() string {
+	return ""
+}
+
+func (res *redirectResponse) StatusCode() int {
+	return redirectStatusCode(false)
+}
+
+func (res *redirectResponse) Header() http.Header {
+	return res.header
+}
+
+func (res *redirectResponse) WriteTo(w http.ResponseWriter, r *http.Request) {
+	writeResponse(res, w, r)
+}
@@ -11,7 +11,8 @@ import (
 )
 
 var (
-	ErrKnownNonce = errors.New("nonce is known")
+	ErrKnownNonce   = errors.New("nonce is known")
+	ErrNonceExpired = errors.New("nonce is out of the accepted window")
 )
 
 type Session interface {
@@ -21,8 +22,13 @@ type Session interface {
 	GetResponse() (Response, error)
 }
 
-func SessionFromMessage(p *Provider, method string, msg gopenid.Message) (s Session, err error) {
-	req, err := RequestFromMessage(method, msg)
+// SessionFromMessage returns Session for msg, assuming msg arrived over an insecure transport.
+func SessionFromMessage(p *Provider, method string, msg *gopenid.Message) (Session, error) {
+	return sessionFromMessage(p, method, msg, Transport{})
+}
+
+func sessionFromMessage(p *Provider, method string, msg *gopenid.Message, transport Transport) (s Session, err error) {
+	req, err := requestFromMessage(method, msg, p.realmPolicy, p.maxDHModulusBits)
 	if err != nil {
 		return
 	}
@@ -31,7 +37,9 @@ func SessionFromMessage(p *Provider, method string, msg gopenid.Message) (s Sess
 	case *checkIDRequest:
 		s = new(CheckIDSession)
 	case *associateRequest:
-		s = new(AssociateSession)
+		s = &AssociateSession{
+			transport: transport,
+		}
 	case *checkAuthenticationRequest:
 		s = new(CheckAuthenticationSession)
 	}
@@ -45,9 +53,10 @@ type CheckIDSession struct {
 	provider *Provider
 	request  *checkIDRequest
 
-	accepted  bool
-	identity  string
-	claimedId string
+	accepted   bool
+	identity   string
+	claimedId  string
+	attributes map[gopenid.NamespaceURI][]string
 }
 
 func (s *CheckIDSession) SetProvider(p *Provider) {
@@ -62,12 +71,31 @@ func (s *CheckIDSession) GetRequest() Request {
 	return s.request
 }
 
+// GetCheckIDRequest returns the request of s, e.g. for consent pages to show.
+func (s *CheckIDSession) GetCheckIDRequest() CheckIDRequest {
+	return s.request
+}
+
 func (s *CheckIDSession) Accept(identity, claimedId string) {
 	s.accepted = true
 	s.identity = identity
 	s.claimedId = claimedId
 }
 
+// SetAttributes limits extension arguments of the positive assertion to keys listed by extension namespace.
+// Arguments of all extensions are sent if attributes is nil.
+func (s *CheckIDSession) SetAttributes(attributes map[gopenid.NamespaceURI][]string) {
+	s.attributes = attributes
+}
+
+// Reject makes s respond with a negative assertion.
+func (s *CheckIDSession) Reject() {
+	s.accepted = false
+	s.identity = ""
+	s.claimedId = ""
+	s.attributes = nil
+}
+
 func (s *CheckIDSession) GetResponse() (Response, error) {
 	return s.buildResponse()
 }
@@ -97,6 +125,13 @@ func (s *CheckIDSession) buildResponse() (res *openIDResponse, err error) {
 			order = order[:len(order)-1]
 		}
 
+		var extensions []string
+		extensions, err = addExtensions(s.provider.extensions, s.request, s.identity, s.attributes, res)
+		if err != nil {
+			return
+		}
+		order = append(order, extensions...)
+
 		err = s.provider.signer.Sign(res, s.request.assocHandle.String(), order)
 	} else {
 		res = s.getRejectedResponse()
@@ -111,8 +146,8 @@ func (s *CheckIDSession) getAcceptedResponse() (res *openIDResponse, err error)
 		claimedId gopenid.MessageValue
 	)
 
-	switch s.request.identity.String() {
-	case gopenid.NsIdentifierSelect.String():
+	switch requested := s.request.identity.String(); {
+	case requested == gopenid.NsIdentifierSelect.String():
 		if s.identity == "" {
 			err = ErrIdentityNotSet
 			return
@@ -123,17 +158,36 @@ func (s *CheckIDSession) getAcceptedResponse() (res *openIDResponse, err error)
 		if claimedId == "" {
 			claimedId = identity
 		}
-	case s.identity:
-		identity = s.request.identity
-		claimedId = s.request.claimedId
-	case "":
+	case requested == "":
 		if s.identity != "" {
 			err = ErrIdentitySet
 			return
 		}
+	case sameIdentifier(requested, s.identity):
+		identity = s.request.identity
+		claimedId = s.request.claimedId
 	default:
-		err = ErrIdentityNotMatched
-		return
+		// the accepted identity may be another identifier of the same account
+		matched := false
+		if resolver := s.provider.identityResolver; resolver != nil {
+			matched, err = sameAccount(resolver, s.identity, s.request.identity.String())
+			if err != nil {
+				return
+			}
+		}
+		if !matched {
+			err = ErrIdentityNotMatched
+			return
+		}
+
+		identity = s.request.identity
+		claimedId = s.request.claimedId
+	}
+
+	if s.request.GetNamespace() == gopenid.NsOpenID20 {
+		if err = verifyDelegation(s.provider.identityResolver, claimedId.String(), identity.String()); err != nil {
+			return
+		}
 	}
 
 	res = newOpenIDResponse(s.request)
@@ -142,12 +196,16 @@ func (s *CheckIDSession) getAcceptedResponse() (res *openIDResponse, err error)
 		gopenid.NewMessageKey(s.request.GetNamespace(), "op_endpoint"),
 		gopenid.MessageValue(s.provider.endpoint),
 	)
-	res.AddArg(gopenid.NewMessageKey(s.request.GetNamespace(), "claimed_id"), claimedId)
-	res.AddArg(gopenid.NewMessageKey(s.request.GetNamespace(), "identity"), identity)
+	if identity != "" {
+		if s.request.GetNamespace() == gopenid.NsOpenID20 {
+			// OpenID 1.x relying parties keep the claimed identifier by themselves
+			res.AddArg(gopenid.NewMessageKey(s.request.GetNamespace(), "claimed_id"), claimedId)
+		}
+		res.AddArg(gopenid.NewMessageKey(s.request.GetNamespace(), "identity"), identity)
+	}
 	res.AddArg(gopenid.NewMessageKey(s.request.GetNamespace(), "return_to"), s.request.returnTo)
 
-	nonce := gopenid.GenerateNonce(time.Now().UTC())
-	s.provider.store.StoreNonce(nonce.String())
+	nonce := gopenid.GenerateNonce(s.provider.now().UTC())
 	res.AddArg(
 		gopenid.NewMessageKey(s.request.GetNamespace(), "response_nonce"),
 		nonce,
@@ -161,6 +219,10 @@ func (s *CheckIDSession) getRejectedResponse() (res *openIDResponse) {
 	var mode gopenid.MessageValue = "cancel"
 	if s.request.mode == "checkid_immediate" {
 		mode = "setup_needed"
+		if s.request.GetNamespace() != gopenid.NsOpenID20 {
+			// OpenID 1.x tells it by id_res with user_setup_url
+			mode = "id_res"
+		}
 
 		setupmsg := s.request.message.Copy()
 		setupmsg.AddArg(
@@ -168,7 +230,7 @@ func (s *CheckIDSession) getRejectedResponse() (res *openIDResponse) {
 			"checkid_setup",
 		)
 		setupUrl, _ := url.Parse(s.provider.endpoint)
-		setupUrl.RawQuery = setupmsg.ToQuery().Encode()
+		setupUrl.RawQuery = setupmsg.Encode()
 		res.AddArg(
 			gopenid.NewMessageKey(s.request.GetNamespace(), "user_setup_url"),
 			gopenid.MessageValue(setupUrl.String()),
@@ -180,8 +242,9 @@ func (s *CheckIDSession) getRejectedResponse() (res *openIDResponse) {
 }
 
 type AssociateSession struct {
-	provider *Provider
-	request  *associateRequest
+	provider  *Provider
+	request   *associateRequest
+	transport Transport
 }
 
 func (s *AssociateSession) SetProvider(p *Provider) {
@@ -203,16 +266,27 @@ func (s *AssociateSession) GetResponse() (Response, error) {
 func (s *AssociateSession) buildResponse() (res *openIDResponse, err error) {
 	if s.request.err != nil {
 		return s.buildFailedResponse(s.request.err.Error()), nil
+	} else if !s.provider.assocPairs.isAllowed(s.request.sessionType, s.request.assocType) {
+		return s.buildFailedResponse(ErrAssociationPairRejected.Error()), nil
+	} else if s.request.sessionType.Name() == gopenid.SessionNoEncryption.Name() && !s.transport.Secure && !s.provider.allowInsecureNoEncryption {
+		// MAC key must not be sent in clear text over an insecure transport
+		return s.buildFailedResponse(ErrInsecureNoEncryption.Error()), nil
 	}
 
 	assoc, err := s.provider.signer.createAssociation(s.request.assocType, false)
 	if err != nil {
+		s.provider.logf("creating association failed: %v", err)
 		return s.buildFailedResponse(err.Error()), nil
 	}
 
-	s.provider.store.StoreAssociation(assoc)
+	if err = s.provider.signer.storeAssociation(assoc); err != nil {
+		s.provider.logf("storing association failed: %v", err)
+		return s.buildFailedResponse(err.Error()), nil
+	}
 
 	res = newOpenIDResponse(s.request)
+	// the MAC key, in clear or encrypted, must not be kept by caches
+	res.Header().Set("Cache-Control", "no-store")
 	res.AddArg(
 		gopenid.NewMessageKey(res.GetNamespace(), "assoc_handle"),
 		gopenid.MessageValue(assoc.GetHandle()),
@@ -225,9 +299,11 @@ func (s *AssociateSession) buildResponse() (res *openIDResponse, err error) {
 		gopenid.NewMessageKey(res.GetNamespace(), "assoc_type"),
 		gopenid.MessageValue(s.request.assocType.Name()),
 	)
+	// expires_in is the lifetime of the association in seconds, not the time it expires at
+	expiresIn := int64(assoc.GetExpires().Sub(s.provider.now()) / time.Second)
 	res.AddArg(
 		gopenid.NewMessageKey(res.GetNamespace(), "expires_in"),
-		gopenid.MessageValue(strconv.FormatInt(assoc.GetExpires().Unix(), 10)),
+		gopenid.MessageValue(strconv.FormatInt(expiresIn, 10)),
 	)
 
 	if s.request.sessionType.Name() == gopenid.SessionNoEncryption.Name() {
@@ -278,7 +354,15 @@ func (s *AssociateSession) buildResponse() (res *openIDResponse, err error) {
 }
 
 func (s *AssociateSession) buildFailedResponse(err string) (res *openIDResponse) {
+	preferred, hasPreferred := s.provider.assocPairs.preferred(s.request.sessionType, s.request.assocType)
+	if hasPreferred && preferred.SessionType.Name() == gopenid.SessionNoEncryption.Name() && !s.transport.Secure && !s.provider.allowInsecureNoEncryption {
+		if encrypted, ok := s.provider.assocPairs.preferredEncrypted(s.request.assocType); ok {
+			preferred = encrypted
+		}
+	}
+
 	res = newOpenIDResponse(s.request)
+	res.isError = true
 	res.AddArg(
 		gopenid.NewMessageKey(res.GetNamespace(), "error"),
 		gopenid.MessageValue(err),
@@ -287,14 +371,18 @@ func (s *AssociateSession) buildFailedResponse(err string) (res *openIDResponse)
 		gopenid.NewMessageKey(res.GetNamespace(), "error_code"),
 		"unsupported-type",
 	)
-	res.AddArg(
-		gopenid.NewMessageKey(res.GetNamespace(), "session_type"),
-		gopenid.MessageValue(gopenid.DefaultSession.Name()),
-	)
-	res.AddArg(
-		gopenid.NewMessageKey(res.GetNamespace(), "assoc_type"),
-		gopenid.MessageValue(gopenid.DefaultAssoc.Name()),
-	)
+
+	// nothing is suggested if no allowed pair is registered
+	if hasPreferred {
+		res.AddArg(
+			gopenid.NewMessageKey(res.GetNamespace(), "session_type"),
+			gopenid.MessageValue(preferred.SessionType.Name()),
+		)
+		res.AddArg(
+			gopenid.NewMessageKey(res.GetNamespace(), "assoc_type"),
+			gopenid.MessageValue(preferred.AssocType.Name()),
+		)
+	}
 
 	return
 }
@@ -321,6 +409,14 @@ func (s *CheckAuthenticationSession) GetResponse() (Response, error) {
 }
 
 func (s *CheckAuthenticationSession) buildResponse() (res *openIDResponse, err error) {
+	issued, err := gopenid.ParseNonce(s.request.responseNonce)
+	if err != nil {
+		return
+	} else if now := s.provider.now(); issued.Before(now.Add(-s.provider.nonceWindow)) || issued.After(now.Add(s.provider.nonceWindow)) {
+		err = ErrNonceExpired
+		return
+	}
+
 	if s.provider.store.IsKnownNonce(s.request.responseNonce.String()) {
 		err = ErrKnownNonce
 		return
@@ -330,6 +426,7 @@ func (s *CheckAuthenticationSession) buildResponse() (res *openIDResponse, err e
 	if err != nil {
 		return
 	}
+	s.provider.store.StoreNonce(s.request.responseNonce.String())
 
 	res = newOpenIDResponse(s.request)
 
@@ -1,6 +1,7 @@
 package provider
 
 import (
+	"crypto/hmac"
 	"errors"
 	"io"
 	"strings"
@@ -21,21 +22,42 @@ var (
 )
 
 type Signer struct {
-	store    gopenid.Store
-	lifetime time.Duration
+	store             gopenid.Store
+	lifetime          time.Duration
+	statelessLifetime time.Duration
+	clock             func() time.Time
+	keyring           *gopenid.Keyring
+	macSigner         gopenid.MACSigner
+	assocPairs        associationPairs
 
 	secretGenerator io.Reader
 }
 
 func NewSigner(store gopenid.Store, lifetime time.Duration, secretGenerator io.Reader) *Signer {
 	return &Signer{
-		store:    store,
-		lifetime: lifetime,
+		store:             store,
+		lifetime:          lifetime,
+		statelessLifetime: lifetime,
+		clock:             time.Now,
 
 		secretGenerator: secretGenerator,
 	}
 }
 
+// SetKeyring makes s seal association secrets with keyring before they are stored,
+// and open them when they are loaded from the store.
+func (s *Signer) SetKeyring(keyring *gopenid.Keyring) {
+	s.keyring = keyring
+}
+
+// SetMACSigner makes s compute signatures with macSigner.
+//
+// If macSigner also implements gopenid.MACKeyManager, secrets of associations
+// are imported to it and are not persisted in the store.
+func (s *Signer) SetMACSigner(macSigner gopenid.MACSigner) {
+	s.macSigner = macSigner
+}
+
 func (s *Signer) createAssociation(assocType gopenid.AssocType, isStateless bool) (assoc *gopenid.Association, err error) {
 	handle := uuid.New().String()
 	secret := make([]byte, assocType.GetSecretSize())
@@ -43,16 +65,84 @@ func (s *Signer) createAssociation(assocType gopenid.AssocType, isStateless bool
 	if err != nil {
 		return
 	}
-	expires := time.Now().Add(s.lifetime)
+	expires := s.clock().Add(s.lifetime)
+	if isStateless {
+		expires = s.clock().Add(s.statelessLifetime)
+	}
 
 	assoc = gopenid.NewAssociation(assocType, handle, secret, expires, isStateless)
+	assoc.SetMACSigner(s.macSigner)
 	return
 }
 
+// createStatelessAssociation creates an association with the most preferred association type.
+// Without association pairs, gopenid.DefaultAssoc is used.
+func (s *Signer) createStatelessAssociation() (assoc *gopenid.Association, err error) {
+	assocType := gopenid.DefaultAssoc
+	if s.assocPairs != nil {
+		pair, ok := s.assocPairs.preferred(gopenid.SessionType{}, gopenid.AssocType{})
+		if !ok {
+			err = ErrNoAvailableAssociation
+			return
+		}
+		assocType = pair.AssocType
+	}
+
+	return s.createAssociation(assocType, true)
+}
+
+func (s *Signer) storeAssociation(assoc *gopenid.Association) error {
+	if manager, ok := s.macSigner.(gopenid.MACKeyManager); ok {
+		if err := manager.ImportKey(assoc); err != nil {
+			return err
+		}
+
+		assoc = gopenid.NewAssociation(assoc.GetAssocType(), assoc.GetHandle(), nil, assoc.GetExpires(), assoc.IsStateless())
+	} else if s.keyring != nil {
+		sealed, err := s.keyring.Seal(assoc.GetSecret(), []byte(assoc.GetHandle()), s.secretGenerator)
+		if err != nil {
+			return err
+		}
+
+		assoc = gopenid.NewAssociation(assoc.GetAssocType(), assoc.GetHandle(), sealed, assoc.GetExpires(), assoc.IsStateless())
+	}
+
+	s.store.StoreAssociation(assoc)
+	return nil
+}
+
+func (s *Signer) getAssociation(handle string, isStateless bool) (*gopenid.Association, bool) {
+	assoc, ok := s.store.GetAssociation(handle, isStateless)
+	if !ok {
+		return nil, false
+	}
+
+	if _, isManager := s.macSigner.(gopenid.MACKeyManager); !isManager && s.keyring != nil {
+		secret, err := s.keyring.Open(assoc.GetSecret(), []byte(assoc.GetHandle()), s.clock())
+		if err != nil {
+			// sealed by a key which is no longer usable
+			return nil, false
+		}
+
+		assoc = gopenid.NewAssociation(assoc.GetAssocType(), assoc.GetHandle(), secret, assoc.GetExpires(), assoc.IsStateless())
+	}
+
+	assoc.SetMACSigner(s.macSigner)
+	return assoc, true
+}
+
+func (s *Signer) deleteAssociation(assoc *gopenid.Association) {
+	s.store.DeleteAssociation(assoc)
+
+	if manager, ok := s.macSigner.(gopenid.MACKeyManager); ok {
+		manager.DeleteKey(assoc.GetHandle())
+	}
+}
+
 func (s *Signer) Invalidate(handle string, isStateless bool) {
 	assoc, ok := s.store.GetAssociation(handle, isStateless)
 	if ok {
-		s.store.DeleteAssociation(assoc)
+		s.deleteAssociation(assoc)
 	}
 
 	return
@@ -75,7 +165,7 @@ func (s *Signer) Verify(req Request, isStateless bool) (ok bool, err error) {
 		return
 	}
 
-	assoc, ok := s.store.GetAssociation(assocHandle.String(), isStateless)
+	assoc, ok := s.getAssociation(assocHandle.String(), isStateless)
 	if !ok {
 		err = gopenid.ErrAssociationNotFound
 		return
@@ -91,7 +181,7 @@ func (s *Signer) Verify(req Request, isStateless bool) (ok bool, err error) {
 	expected, _ := verify.GetArg(
 		gopenid.NewMessageKey(verify.GetOpenIDNamespace(), "sig"),
 	)
-	ok = sig == expected
+	ok = hmac.Equal(sig.Bytes(), expected.Bytes())
 
 	return
 }
@@ -100,18 +190,18 @@ func (s *Signer) Sign(res *openIDResponse, assocHandle string, order []string) (
 	var assoc *gopenid.Association
 
 	if assocHandle == "" {
-		assoc, err = s.createAssociation(gopenid.DefaultAssoc, true)
+		assoc, err = s.createStatelessAssociation()
 	} else {
 		var ok bool
 
-		assoc, ok = s.store.GetAssociation(assocHandle, false)
-		if !ok || !assoc.IsValid() {
+		assoc, ok = s.getAssociation(assocHandle, false)
+		if !ok || !s.clock().Before(assoc.GetExpires()) {
 			res.AddArg(
 				gopenid.NewMessageKey(res.GetNamespace(), "invalidate_handle"),
 				gopenid.MessageValue(assocHandle),
 			)
 
-			assoc, err = s.createAssociation(gopenid.DefaultAssoc, true)
+			assoc, err = s.createStatelessAssociation()
 		}
 	}
 
@@ -119,15 +209,17 @@ func (s *Signer) Sign(res *openIDResponse, assocHandle string, order []string) (
 		return
 	}
 
-	if assoc.IsStateless() {
-		s.store.StoreAssociation(assoc)
-	} else {
-		s.store.DeleteAssociation(assoc)
+	if err = assoc.Sign(res.message, order); err != nil {
+		return
 	}
 
-	return assoc.Sign(res.message, order)
+	// associations established by relying parties are shared, and are reused until they expire
+	if assoc.IsStateless() {
+		err = s.storeAssociation(assoc)
+	}
+	return
 }
 
 func (s *Signer) getExpires() time.Time {
-	return time.Now().Add(s.lifetime)
+	return s.clock().Add(s.lifetime)
 }
@@ -0,0 +1,199 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryStore struct {
+	stateful  map[string]*gopenid.Association
+	stateless map[string]*gopenid.Association
+	nonces    map[string]bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		stateful:  make(map[string]*gopenid.Association),
+		stateless: make(map[string]*gopenid.Association),
+		nonces:    make(map[string]bool),
+	}
+}
+
+func (s *memoryStore) assocs(isStateless bool) map[string]*gopenid.Association {
+	if isStateless {
+		return s.stateless
+	}
+	return s.stateful
+}
+
+func (s *memoryStore) StoreAssociation(assoc *gopenid.Association) {
+	s.assocs(assoc.IsStateless())[assoc.GetHandle()] = assoc
+}
+
+func (s *memoryStore) GetAssociation(handle string, isStateless bool) (*gopenid.Association, bool) {
+	assoc, ok := s.assocs(isStateless)[handle]
+	return assoc, ok
+}
+
+func (s *memoryStore) DeleteAssociation(assoc *gopenid.Association) {
+	delete(s.assocs(assoc.IsStateless()), assoc.GetHandle())
+}
+
+func (s *memoryStore) IsKnownNonce(nonce string) bool {
+	return s.nonces[nonce]
+}
+
+func (s *memoryStore) StoreNonce(nonce string) {
+	s.nonces[nonce] = true
+}
+
+func TestSignerKeyring(t *testing.T) {
+	store := newMemoryStore()
+	signer := NewSigner(store, time.Hour, rand.Reader)
+
+	keyring := gopenid.NewKeyring(time.Hour)
+	if _, err := keyring.Rotate(rand.Reader, time.Now()); !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	signer.SetKeyring(keyring)
+
+	assoc, err := signer.createAssociation(gopenid.DefaultAssoc, false)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	if !assert.Nil(t, signer.storeAssociation(assoc)) {
+		t.FailNow()
+	}
+
+	// secret is never stored in plain
+	stored, ok := store.GetAssociation(assoc.GetHandle(), false)
+	if assert.True(t, ok) {
+		assert.False(t, bytes.Contains(stored.GetSecret(), assoc.GetSecret()))
+	}
+
+	// rotated keyring still opens the secret
+	if _, err := keyring.Rotate(rand.Reader, time.Now()); !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	if loaded, ok := signer.getAssociation(assoc.GetHandle(), false); assert.True(t, ok) {
+		assert.Equal(t, loaded.GetSecret(), assoc.GetSecret())
+	}
+
+	// until the key which sealed it is pruned
+	keyring.Prune(time.Now().Add(2 * time.Hour))
+	_, ok = signer.getAssociation(assoc.GetHandle(), false)
+	assert.False(t, ok)
+}
+
+type fakeKeyManager struct {
+	secrets map[string][]byte
+}
+
+func (m *fakeKeyManager) MAC(assoc *gopenid.Association, data []byte) ([]byte, error) {
+	secret, ok := m.secrets[assoc.GetHandle()]
+	if !ok {
+		return nil, gopenid.ErrAssociationNotFound
+	}
+
+	withSecret := gopenid.NewAssociation(assoc.GetAssocType(), assoc.GetHandle(), secret, assoc.GetExpires(), assoc.IsStateless())
+	return gopenid.HMACSigner{}.MAC(withSecret, data)
+}
+
+func (m *fakeKeyManager) ImportKey(assoc *gopenid.Association) error {
+	m.secrets[assoc.GetHandle()] = assoc.GetSecret()
+	return nil
+}
+
+func (m *fakeKeyManager) DeleteKey(handle string) error {
+	delete(m.secrets, handle)
+	return nil
+}
+
+func TestSignerMACKeyManager(t *testing.T) {
+	store := newMemoryStore()
+	manager := &fakeKeyManager{
+		secrets: make(map[string][]byte),
+	}
+	signer := NewSigner(store, time.Hour, rand.Reader)
+	signer.SetMACSigner(manager)
+
+	assoc, err := signer.createAssociation(gopenid.DefaultAssoc, true)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	if !assert.Nil(t, signer.storeAssociation(assoc)) {
+		t.FailNow()
+	}
+
+	// secret lives only in the key manager
+	if stored, ok := store.GetAssociation(assoc.GetHandle(), true); assert.True(t, ok) {
+		assert.Nil(t, stored.GetSecret())
+	}
+	assert.Equal(t, manager.secrets[assoc.GetHandle()], assoc.GetSecret())
+
+	loaded, ok := signer.getAssociation(assoc.GetHandle(), true)
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+
+	expected := gopenid.NewMessage(gopenid.NsOpenID20)
+	expected.AddArg(gopenid.NewMessageKey(gopenid.NsOpenID20, "mode"), "id_res")
+	actual := expected.Copy()
+
+	if assert.Nil(t, assoc.Sign(expected, []string{"mode"})) && assert.Nil(t, loaded.Sign(actual, []string{"mode"})) {
+		assert.Equal(t, actual.ToQuery(), expected.ToQuery())
+	}
+
+	signer.Invalidate(assoc.GetHandle(), true)
+	assert.Empty(t, manager.secrets)
+}
+
+func TestSignerSignWithStatefulAssociation(t *testing.T) {
+	store := newMemoryStore()
+	manager := &fakeKeyManager{
+		secrets: make(map[string][]byte),
+	}
+	signer := NewSigner(store, time.Hour, rand.Reader)
+	signer.SetMACSigner(manager)
+
+	assoc, err := signer.createAssociation(gopenid.DefaultAssoc, false)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	if !assert.Nil(t, signer.storeAssociation(assoc)) {
+		t.FailNow()
+	}
+
+	// relying parties may reuse their handle for many assertions
+	for i := 0; i < 2; i++ {
+		res := &openIDResponse{
+			message: gopenid.NewMessage(gopenid.NsOpenID20),
+		}
+		res.AddArg(gopenid.NewMessageKey(gopenid.NsOpenID20, "mode"), "id_res")
+
+		if !assert.Nil(t, signer.Sign(res, assoc.GetHandle(), []string{"mode"})) {
+			t.FailNow()
+		}
+
+		_, invalidated := res.GetArg(gopenid.NewMessageKey(gopenid.NsOpenID20, "invalidate_handle"))
+		assert.False(t, invalidated)
+		if handle, ok := res.GetArg(gopenid.NewMessageKey(gopenid.NsOpenID20, "assoc_handle")); assert.True(t, ok) {
+			assert.Equal(t, handle.String(), assoc.GetHandle())
+		}
+
+		expected := gopenid.NewMessage(gopenid.NsOpenID20)
+		expected.AddArg(gopenid.NewMessageKey(gopenid.NsOpenID20, "mode"), "id_res")
+		if assert.Nil(t, assoc.Sign(expected, []string{"mode"})) {
+			assert.Equal(t, res.GetMessage().ToQuery(), expected.ToQuery())
+		}
+	}
+
+	_, ok := store.GetAssociation(assoc.GetHandle(), false)
+	assert.True(t, ok)
+	assert.Equal(t, manager.secrets[assoc.GetHandle()], assoc.GetSecret())
+}
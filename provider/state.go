@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+var (
+	ErrKeyringNotSet = errors.New("keyring not set")
+	ErrInvalidState  = errors.New("invalid checkid state")
+	ErrStateExpired  = errors.New("checkid state expired")
+)
+
+// stateAdditionalData binds state tokens to their purpose and the OP Endpoint,
+// so that data sealed for other purposes cannot be resumed.
+func (p *Provider) stateAdditionalData() []byte {
+	return []byte("checkid-state\x00" + p.endpoint)
+}
+
+// SuspendCheckID returns a state token carrying the request of s, e.g. through login pages
+// in a cookie or a URL parameter. ResumeCheckID returns the session back from the token.
+//
+// The token is encrypted and authenticated by the keyring of p, and expires after
+// Config.StateLifetime. Decisions made on s, e.g. by Accept, are not carried.
+func (p *Provider) SuspendCheckID(s *CheckIDSession) (token string, err error) {
+	keyring := p.signer.keyring
+	if keyring == nil {
+		err = ErrKeyringNotSet
+		return
+	}
+
+	msg, err := s.request.message.MarshalBinary()
+	if err != nil {
+		return
+	}
+
+	// plaintext = expires (unix seconds, big endian) || message
+	plaintext := make([]byte, 8, 8+len(msg))
+	binary.BigEndian.PutUint64(plaintext, uint64(p.now().Add(p.stateLifetime).Unix()))
+	plaintext = append(plaintext, msg...)
+
+	sealed, err := keyring.Seal(plaintext, p.stateAdditionalData(), p.signer.secretGenerator)
+	if err != nil {
+		return
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(sealed)
+	return
+}
+
+// ResumeCheckID returns a new CheckIDSession for the request carried by token of SuspendCheckID.
+// It returns ErrInvalidState if token was not issued by p or was tampered with, and ErrStateExpired
+// if it has expired. The realm is checked again as configured now.
+func (p *Provider) ResumeCheckID(token string) (s *CheckIDSession, err error) {
+	keyring := p.signer.keyring
+	if keyring == nil {
+		err = ErrKeyringNotSet
+		return
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		err = ErrInvalidState
+		return
+	}
+
+	plaintext, err := keyring.Open(sealed, p.stateAdditionalData(), p.now())
+	if err != nil || len(plaintext) < 8 {
+		err = ErrInvalidState
+		return
+	}
+
+	expires := int64(binary.BigEndian.Uint64(plaintext))
+	if p.now().Unix() >= expires {
+		err = ErrStateExpired
+		return
+	}
+
+	msg := new(gopenid.Message)
+	if err = msg.UnmarshalBinary(plaintext[8:]); err != nil {
+		err = ErrInvalidState
+		return
+	}
+
+	session, err := sessionFromMessage(p, "GET", msg, Transport{})
+	if err != nil {
+		return
+	}
+
+	s, ok := session.(*CheckIDSession)
+	if !ok {
+		err = ErrInvalidState
+	}
+	return
+}
@@ -0,0 +1,88 @@
+package provider
+
+import (
+	"crypto/rand"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIDState(t *testing.T) {
+	keyring := gopenid.NewKeyring(time.Hour)
+	if _, err := keyring.Rotate(rand.Reader, time.Now()); !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	now := time.Now()
+	p, err := New(endpoint, newMemoryStore(),
+		WithKeyring(keyring),
+		WithStateLifetime(10*time.Minute),
+		WithClock(func() time.Time { return now }),
+	)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	s := establishCheckIDSession(t, p, url.Values{
+		"openid.ns":         []string{gopenid.NsOpenID20.String()},
+		"openid.mode":       []string{"checkid_setup"},
+		"openid.identity":   []string{gopenid.NsIdentifierSelect.String()},
+		"openid.claimed_id": []string{gopenid.NsIdentifierSelect.String()},
+		"openid.return_to":  []string{"http://rp.example.com/return"},
+		"openid.ns.ext":     []string{"http://example.com/ext"},
+		"openid.ext.name":   []string{"required"},
+	})
+
+	token, err := p.SuspendCheckID(s)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	resumed, err := p.ResumeCheckID(token)
+	if assert.Nil(t, err) {
+		req := resumed.GetCheckIDRequest()
+		assert.Equal(t, req.ReturnTo(), "http://rp.example.com/return")
+		assert.True(t, req.IsIdentifierSelect())
+		assert.Equal(t, req.Extensions(), s.GetCheckIDRequest().Extensions())
+
+		resumed.Accept("http://example.com/users/alice", "")
+		res, err := resumed.GetResponse()
+		if assert.Nil(t, err) {
+			location, _ := url.Parse(res.GetRedirectTo())
+			assert.Equal(t, location.Query().Get("openid.mode"), "id_res")
+			assert.Equal(t, location.Query().Get("openid.identity"), "http://example.com/users/alice")
+		}
+	}
+
+	// tampered
+	tampered := []byte(token)
+	tampered[len(tampered)/2] ^= 1
+	_, err = p.ResumeCheckID(string(tampered))
+	assert.Equal(t, err, ErrInvalidState)
+	_, err = p.ResumeCheckID("not a token")
+	assert.Equal(t, err, ErrInvalidState)
+
+	// sealed by the same keyring for another OP Endpoint
+	other, err := New("http://other.example.com/openid", newMemoryStore(), WithKeyring(keyring))
+	if assert.Nil(t, err) {
+		_, err = other.ResumeCheckID(token)
+		assert.Equal(t, err, ErrInvalidState)
+	}
+
+	// expired
+	now = now.Add(10 * time.Minute)
+	_, err = p.ResumeCheckID(token)
+	assert.Equal(t, err, ErrStateExpired)
+
+	// no keyring
+	p, err = New(endpoint, newMemoryStore())
+	if assert.Nil(t, err) {
+		_, err = p.SuspendCheckID(s)
+		assert.Equal(t, err, ErrKeyringNotSet)
+		_, err = p.ResumeCheckID(token)
+		assert.Equal(t, err, ErrKeyringNotSet)
+	}
+}
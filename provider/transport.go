@@ -0,0 +1,39 @@
+package provider
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Transport describes how a request reached the provider.
+type Transport struct {
+	// Secure is true if the request arrived over TLS.
+	Secure bool
+}
+
+// TransportFromRequest returns Transport of r.
+//
+// X-Forwarded-Proto is honored only if trustForwarded is true, which must be the case
+// only behind a reverse proxy terminating TLS. Only the last value, which was appended
+// by the nearest proxy, is used, as the client can send the header on its own.
+func TransportFromRequest(r *http.Request, trustForwarded bool) Transport {
+	if r.TLS != nil {
+		return Transport{Secure: true}
+	}
+
+	if trustForwarded {
+		var proto string
+		if values := r.Header.Values("X-Forwarded-Proto"); len(values) > 0 {
+			proto = values[len(values)-1]
+		}
+		if idx := strings.LastIndex(proto, ","); idx > -1 {
+			proto = proto[idx+1:]
+		}
+
+		return Transport{
+			Secure: strings.EqualFold(strings.TrimSpace(proto), "https"),
+		}
+	}
+
+	return Transport{}
+}
@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportFromRequest(t *testing.T) {
+	for _, testCase := range []struct {
+		tls            bool
+		forwardedProto string
+		trustForwarded bool
+		expected       bool
+	}{
+		{tls: true, expected: true},
+		{tls: false, expected: false},
+		{forwardedProto: "https", trustForwarded: false, expected: false},
+		{forwardedProto: "https", trustForwarded: true, expected: true},
+		{forwardedProto: "HTTPS, http", trustForwarded: true, expected: false},
+		{forwardedProto: "http, https", trustForwarded: true, expected: true},
+		{forwardedProto: "https, HTTPS ", trustForwarded: true, expected: true},
+	} {
+		r, _ := http.NewRequest("POST", "http://example.com/openid", nil)
+		if testCase.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if testCase.forwardedProto != "" {
+			r.Header.Set("X-Forwarded-Proto", testCase.forwardedProto)
+		}
+
+		assert.Equal(t, TransportFromRequest(r, testCase.trustForwarded).Secure, testCase.expected, "%+v", testCase)
+	}
+
+	// a header sent by the client comes before the one added by the proxy
+	r, _ := http.NewRequest("POST", "http://example.com/openid", nil)
+	r.Header.Add("X-Forwarded-Proto", "https")
+	r.Header.Add("X-Forwarded-Proto", "http")
+	assert.False(t, TransportFromRequest(r, true).Secure)
+}
@@ -0,0 +1,176 @@
+package provider
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+var (
+	ErrTrustNotFound = errors.New("trust decision not found")
+)
+
+// TrustDecision records that a user approved sending assertions to a realm.
+type TrustDecision struct {
+	UserID string
+	Realm  Realm
+	// Identity and ClaimedID are what was asserted when the user approved the realm.
+	Identity  string
+	ClaimedID string
+	// Attributes are keys of extension arguments the user agreed to send, by extension namespace.
+	// Arguments of extensions not listed here are never sent on the decision, unless it is nil,
+	// which means the user agreed to send arguments of all extensions.
+	Attributes map[gopenid.NamespaceURI][]string
+	Created    time.Time
+	// Expires is the time the decision lapses. It never lapses if zero.
+	Expires time.Time
+}
+
+// IsExpired reports whether d has lapsed at now.
+func (d *TrustDecision) IsExpired(now time.Time) bool {
+	return !d.Expires.IsZero() && !now.Before(d.Expires)
+}
+
+// Covers reports whether d answers req without asking the user again,
+// i.e. every extension requested by req and handled by handlers has been consented to.
+// Decisions with nil Attributes cover every request.
+func (d *TrustDecision) Covers(req *AuthRequest, handlers []ExtensionHandler) bool {
+	if d.Attributes == nil {
+		return true
+	}
+
+	for _, handler := range handlers {
+		ns := handler.GetNamespace()
+		if _, requested := req.Extensions[ns]; !requested {
+			continue
+		}
+
+		if _, consented := d.Attributes[ns]; !consented {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *TrustDecision) copy() *TrustDecision {
+	copied := *d
+	if d.Attributes != nil {
+		copied.Attributes = make(map[gopenid.NamespaceURI][]string, len(d.Attributes))
+		for ns, keys := range d.Attributes {
+			copied.Attributes[ns] = append([]string(nil), keys...)
+		}
+	}
+
+	return &copied
+}
+
+// TrustStore persists trust decisions of users, keyed by user and realm.
+type TrustStore interface {
+	// GetTrust returns the decision of userID about realm, or ErrTrustNotFound.
+	GetTrust(userID string, realm Realm) (*TrustDecision, error)
+	// PutTrust stores d, replacing the decision of the same user and realm.
+	PutTrust(d *TrustDecision) error
+	// ListTrust returns all decisions of userID, ordered by realm.
+	ListTrust(userID string) ([]*TrustDecision, error)
+	// RevokeTrust removes the decision of userID about realm, or returns ErrTrustNotFound.
+	RevokeTrust(userID string, realm Realm) error
+}
+
+// MemoryTrustStore is a TrustStore keeping decisions in memory.
+type MemoryTrustStore struct {
+	mu        sync.RWMutex
+	decisions map[string]map[string]*TrustDecision
+}
+
+// NewMemoryTrustStore returns an empty MemoryTrustStore.
+func NewMemoryTrustStore() *MemoryTrustStore {
+	return &MemoryTrustStore{
+		decisions: make(map[string]map[string]*TrustDecision),
+	}
+}
+
+// GetTrust implements TrustStore.
+func (s *MemoryTrustStore) GetTrust(userID string, realm Realm) (*TrustDecision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.decisions[userID][realm.String()]
+	if !ok {
+		return nil, ErrTrustNotFound
+	}
+
+	return d.copy(), nil
+}
+
+// PutTrust implements TrustStore.
+func (s *MemoryTrustStore) PutTrust(d *TrustDecision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	decisions, ok := s.decisions[d.UserID]
+	if !ok {
+		decisions = make(map[string]*TrustDecision)
+		s.decisions[d.UserID] = decisions
+	}
+	decisions[d.Realm.String()] = d.copy()
+
+	return nil
+}
+
+// ListTrust implements TrustStore.
+func (s *MemoryTrustStore) ListTrust(userID string) ([]*TrustDecision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.decisions[userID]))
+	for key := range s.decisions[userID] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ret := make([]*TrustDecision, len(keys))
+	for i, key := range keys {
+		ret[i] = s.decisions[userID][key].copy()
+	}
+
+	return ret, nil
+}
+
+// RevokeTrust implements TrustStore.
+func (s *MemoryTrustStore) RevokeTrust(userID string, realm Realm) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := realm.String()
+	if _, ok := s.decisions[userID][key]; !ok {
+		return ErrTrustNotFound
+	}
+
+	delete(s.decisions[userID], key)
+	if len(s.decisions[userID]) == 0 {
+		delete(s.decisions, userID)
+	}
+
+	return nil
+}
+
+// Prune removes decisions which have lapsed at now.
+func (s *MemoryTrustStore) Prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for userID, decisions := range s.decisions {
+		for key, d := range decisions {
+			if d.IsExpired(now) {
+				delete(decisions, key)
+			}
+		}
+		if len(decisions) == 0 {
+			delete(s.decisions, userID)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SQLTrustStore is a TrustStore keeping decisions in a SQL database.
+//
+// It expects a table like below.
+//
+//	CREATE TABLE openid_trust (
+//		user_id    VARCHAR(255)  NOT NULL,
+//		realm      VARCHAR(2048) NOT NULL,
+//		identity   TEXT          NOT NULL,
+//		claimed_id TEXT          NOT NULL,
+//		attributes TEXT          NOT NULL,
+//		created    BIGINT        NOT NULL,
+//		expires    BIGINT        NOT NULL,
+//		PRIMARY KEY (user_id, realm)
+//	)
+//
+// Times are stored as Unix time in seconds, and expires is 0 for decisions which never lapse.
+type SQLTrustStore struct {
+	db    *sql.DB
+	table string
+
+	// Placeholder returns the bind parameter for the n-th argument, counting from 1.
+	// It returns "?" by default. Use DollarPlaceholder for PostgreSQL.
+	Placeholder func(n int) string
+}
+
+// NewSQLTrustStore returns a SQLTrustStore using table of db.
+func NewSQLTrustStore(db *sql.DB, table string) *SQLTrustStore {
+	return &SQLTrustStore{
+		db:    db,
+		table: table,
+
+		Placeholder: func(int) string { return "?" },
+	}
+}
+
+// DollarPlaceholder returns $n.
+func DollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (s *SQLTrustStore) query(format string, nargs int) string {
+	args := make([]interface{}, 0, nargs+1)
+	args = append(args, s.table)
+	for i := 1; i <= nargs; i++ {
+		args = append(args, s.Placeholder(i))
+	}
+
+	return fmt.Sprintf(format, args...)
+}
+
+// GetTrust implements TrustStore.
+func (s *SQLTrustStore) GetTrust(userID string, realm Realm) (*TrustDecision, error) {
+	row := s.db.QueryRow(
+		s.query("SELECT user_id, realm, identity, claimed_id, attributes, created, expires FROM %s WHERE user_id = %s AND realm = %s", 2),
+		userID, realm.String(),
+	)
+
+	d, err := scanTrustDecision(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrTrustNotFound
+	}
+
+	return d, err
+}
+
+// PutTrust implements TrustStore.
+func (s *SQLTrustStore) PutTrust(d *TrustDecision) (err error) {
+	attributes, err := json.Marshal(d.Attributes)
+	if err != nil {
+		return
+	}
+
+	var expires int64
+	if !d.Expires.IsZero() {
+		expires = d.Expires.Unix()
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	key := d.Realm.String()
+	if _, err = tx.Exec(s.query("DELETE FROM %s WHERE user_id = %s AND realm = %s", 2), d.UserID, key); err != nil {
+		return
+	}
+
+	_, err = tx.Exec(
+		s.query("INSERT INTO %s (user_id, realm, identity, claimed_id, attributes, created, expires) VALUES (%s, %s, %s, %s, %s, %s, %s)", 7),
+		d.UserID, key, d.Identity, d.ClaimedID, string(attributes), d.Created.Unix(), expires,
+	)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// ListTrust implements TrustStore.
+func (s *SQLTrustStore) ListTrust(userID string) (decisions []*TrustDecision, err error) {
+	rows, err := s.db.Query(
+		s.query("SELECT user_id, realm, identity, claimed_id, attributes, created, expires FROM %s WHERE user_id = %s ORDER BY realm", 1),
+		userID,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d *TrustDecision
+		if d, err = scanTrustDecision(rows); err != nil {
+			return
+		}
+		decisions = append(decisions, d)
+	}
+
+	err = rows.Err()
+	return
+}
+
+// RevokeTrust implements TrustStore.
+func (s *SQLTrustStore) RevokeTrust(userID string, realm Realm) error {
+	res, err := s.db.Exec(s.query("DELETE FROM %s WHERE user_id = %s AND realm = %s", 2), userID, realm.String())
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTrustNotFound
+	}
+
+	return nil
+}
+
+// Prune removes decisions which have lapsed at now.
+func (s *SQLTrustStore) Prune(now time.Time) error {
+	_, err := s.db.Exec(s.query("DELETE FROM %s WHERE expires > 0 AND expires <= %s", 1), now.Unix())
+	return err
+}
+
+type trustRowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTrustDecision(row trustRowScanner) (d *TrustDecision, err error) {
+	var (
+		key        string
+		attributes string
+		created    int64
+		expires    int64
+	)
+
+	d = new(TrustDecision)
+	if err = row.Scan(&d.UserID, &key, &d.Identity, &d.ClaimedID, &attributes, &created, &expires); err != nil {
+		d = nil
+		return
+	}
+
+	if d.Realm, err = ParseRealm(key); err != nil {
+		d = nil
+		return
+	}
+
+	if err = json.Unmarshal([]byte(attributes), &d.Attributes); err != nil {
+		d = nil
+		return
+	}
+
+	d.Created = time.Unix(created, 0)
+	if expires > 0 {
+		d.Expires = time.Unix(expires, 0)
+	}
+
+	return
+}
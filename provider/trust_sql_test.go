@@ -0,0 +1,313 @@
+package provider
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+var errFakeInsert = errors.New("insert failed")
+
+// fakeTrustDB is a database/sql driver understanding the statements of SQLTrustStore on openid_trust.
+type fakeTrustDB struct {
+	mu         sync.Mutex
+	rows       map[[2]string][]driver.Value
+	queries    []string
+	failInsert bool
+}
+
+func newFakeTrustDB() *fakeTrustDB {
+	return &fakeTrustDB{
+		rows: make(map[[2]string][]driver.Value),
+	}
+}
+
+func (db *fakeTrustDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeTrustConn{db: db}, nil
+}
+
+func (db *fakeTrustDB) Driver() driver.Driver {
+	return db
+}
+
+func (db *fakeTrustDB) Open(string) (driver.Conn, error) {
+	return db.Connect(context.Background())
+}
+
+type fakeTrustConn struct {
+	db *fakeTrustDB
+	// staged holds rows modified in the current transaction
+	staged map[[2]string][]driver.Value
+}
+
+func (c *fakeTrustConn) Prepare(query string) (driver.Stmt, error) {
+	c.db.mu.Lock()
+	c.db.queries = append(c.db.queries, query)
+	c.db.mu.Unlock()
+
+	return &fakeTrustStmt{conn: c, query: fakePlaceholders.ReplaceAllString(query, "?")}, nil
+}
+
+func (c *fakeTrustConn) Close() error {
+	return nil
+}
+
+func (c *fakeTrustConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.staged = make(map[[2]string][]driver.Value, len(c.db.rows))
+	for key, row := range c.db.rows {
+		c.staged[key] = row
+	}
+	return c, nil
+}
+
+func (c *fakeTrustConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.rows, c.staged = c.staged, nil
+	return nil
+}
+
+func (c *fakeTrustConn) Rollback() error {
+	c.staged = nil
+	return nil
+}
+
+var fakePlaceholders = regexp.MustCompile(`\$\d+`)
+
+type fakeTrustStmt struct {
+	conn  *fakeTrustConn
+	query string
+}
+
+func (s *fakeTrustStmt) Close() error {
+	return nil
+}
+
+func (s *fakeTrustStmt) NumInput() int {
+	return strings.Count(s.query, "?")
+}
+
+// rows returns the rows s works on, and a func to release them.
+func (s *fakeTrustStmt) rows() (map[[2]string][]driver.Value, func()) {
+	if s.conn.staged != nil {
+		return s.conn.staged, func() {}
+	}
+
+	s.conn.db.mu.Lock()
+	return s.conn.db.rows, s.conn.db.mu.Unlock
+}
+
+func (s *fakeTrustStmt) Exec(args []driver.Value) (driver.Result, error) {
+	rows, release := s.rows()
+	defer release()
+
+	switch {
+	case strings.HasPrefix(s.query, "INSERT INTO openid_trust "):
+		if s.conn.db.failInsert {
+			return nil, errFakeInsert
+		}
+
+		key := [2]string{args[0].(string), args[1].(string)}
+		if _, ok := rows[key]; ok {
+			return nil, errors.New("duplicate primary key")
+		}
+		rows[key] = args
+		return driver.RowsAffected(1), nil
+	case s.query == "DELETE FROM openid_trust WHERE user_id = ? AND realm = ?":
+		key := [2]string{args[0].(string), args[1].(string)}
+		if _, ok := rows[key]; !ok {
+			return driver.RowsAffected(0), nil
+		}
+		delete(rows, key)
+		return driver.RowsAffected(1), nil
+	case s.query == "DELETE FROM openid_trust WHERE expires > 0 AND expires <= ?":
+		var n int64
+		for key, row := range rows {
+			if expires := row[6].(int64); expires > 0 && expires <= args[0].(int64) {
+				delete(rows, key)
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	}
+
+	return nil, fmt.Errorf("unexpected statement: %s", s.query)
+}
+
+func (s *fakeTrustStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, release := s.rows()
+	defer release()
+
+	const columns = "SELECT user_id, realm, identity, claimed_id, attributes, created, expires FROM openid_trust "
+
+	var result [][]driver.Value
+	switch s.query {
+	case columns + "WHERE user_id = ? AND realm = ?":
+		if row, ok := rows[[2]string{args[0].(string), args[1].(string)}]; ok {
+			result = append(result, row)
+		}
+	case columns + "WHERE user_id = ? ORDER BY realm":
+		for key, row := range rows {
+			if key[0] == args[0].(string) {
+				result = append(result, row)
+			}
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i][1].(string) < result[j][1].(string)
+		})
+	default:
+		return nil, fmt.Errorf("unexpected query: %s", s.query)
+	}
+
+	return &fakeTrustRows{rows: result}, nil
+}
+
+type fakeTrustRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeTrustRows) Columns() []string {
+	return []string{"user_id", "realm", "identity", "claimed_id", "attributes", "created", "expires"}
+}
+
+func (r *fakeTrustRows) Close() error {
+	return nil
+}
+
+func (r *fakeTrustRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLTrustStore(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	fake := newFakeTrustDB()
+	store := NewSQLTrustStore(sql.OpenDB(fake), "openid_trust")
+
+	realm, _ := ParseRealm("http://*.example.com/")
+	other, _ := ParseRealm("http://example.org/")
+
+	_, err := store.GetTrust("alice", realm)
+	assert.Equal(t, err, ErrTrustNotFound)
+
+	d := &TrustDecision{
+		UserID:   "alice",
+		Realm:    realm,
+		Identity: "http://example.com/alice",
+		Attributes: map[gopenid.NamespaceURI][]string{
+			"http://example.com/ext/sreg": []string{"email"},
+		},
+		Created: now,
+		Expires: now.Add(time.Hour),
+	}
+	if !assert.Nil(t, store.PutTrust(d)) {
+		t.FailNow()
+	}
+	assert.Nil(t, store.PutTrust(&TrustDecision{UserID: "alice", Realm: other, Created: now}))
+	assert.Nil(t, store.PutTrust(&TrustDecision{UserID: "bob", Realm: other, Created: now}))
+
+	if stored, err := store.GetTrust("alice", realm); assert.Nil(t, err) {
+		assert.Equal(t, stored, d)
+	}
+	if stored, err := store.GetTrust("alice", other); assert.Nil(t, err) {
+		assert.Nil(t, stored.Attributes)
+		assert.True(t, stored.Expires.IsZero())
+	}
+
+	// replaces the decision of the same user and realm
+	d.Identity = "http://example.com/alice2"
+	if assert.Nil(t, store.PutTrust(d)) {
+		if stored, err := store.GetTrust("alice", realm); assert.Nil(t, err) {
+			assert.Equal(t, stored.Identity, "http://example.com/alice2")
+		}
+	}
+
+	// a failed replacement keeps the previous decision
+	fake.failInsert = true
+	d.Identity = "http://example.com/alice3"
+	assert.Equal(t, store.PutTrust(d), errFakeInsert)
+	fake.failInsert = false
+	if stored, err := store.GetTrust("alice", realm); assert.Nil(t, err) {
+		assert.Equal(t, stored.Identity, "http://example.com/alice2")
+	}
+
+	if decisions, err := store.ListTrust("alice"); assert.Nil(t, err) && assert.Len(t, decisions, 2) {
+		assert.Equal(t, decisions[0].Realm.String(), "http://*.example.com/")
+		assert.Equal(t, decisions[1].Realm.String(), "http://example.org/")
+	}
+
+	assert.Nil(t, store.Prune(now.Add(time.Hour)))
+	_, err = store.GetTrust("alice", realm)
+	assert.Equal(t, err, ErrTrustNotFound)
+
+	assert.Nil(t, store.RevokeTrust("alice", other))
+	assert.Equal(t, store.RevokeTrust("alice", other), ErrTrustNotFound)
+	if decisions, err := store.ListTrust("alice"); assert.Nil(t, err) {
+		assert.Empty(t, decisions)
+	}
+	if decisions, err := store.ListTrust("bob"); assert.Nil(t, err) {
+		assert.Len(t, decisions, 1)
+	}
+
+	for _, query := range fake.queries {
+		assert.NotContains(t, query, "$")
+	}
+}
+
+func TestSQLTrustStorePlaceholder(t *testing.T) {
+	fake := newFakeTrustDB()
+	store := NewSQLTrustStore(sql.OpenDB(fake), "openid_trust")
+	store.Placeholder = DollarPlaceholder
+
+	realm, _ := ParseRealm("http://example.org/")
+	assert.Nil(t, store.PutTrust(&TrustDecision{UserID: "alice", Realm: realm, Created: time.Now()}))
+	_, err := store.GetTrust("alice", realm)
+	assert.Nil(t, err)
+
+	assert.Contains(t, fake.queries,
+		"INSERT INTO openid_trust (user_id, realm, identity, claimed_id, attributes, created, expires) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+	)
+	assert.Contains(t, fake.queries,
+		"SELECT user_id, realm, identity, claimed_id, attributes, created, expires FROM openid_trust WHERE user_id = $1 AND realm = $2",
+	)
+	for _, query := range fake.queries {
+		assert.NotContains(t, query, "?")
+	}
+}
+
+func TestSQLTrustStoreMalformedRow(t *testing.T) {
+	fake := newFakeTrustDB()
+	store := NewSQLTrustStore(sql.OpenDB(fake), "openid_trust")
+	realm, _ := ParseRealm("http://example.org/")
+
+	fake.rows[[2]string{"alice", realm.String()}] = []driver.Value{"alice", realm.String(), "", "", "{", int64(0), int64(0)}
+	_, err := store.GetTrust("alice", realm)
+	assert.NotNil(t, err)
+
+	fake.rows[[2]string{"alice", realm.String()}] = []driver.Value{"alice", "http://*.", "", "", "null", int64(0), int64(0)}
+	_, err = store.GetTrust("alice", realm)
+	assert.Equal(t, err, ErrMalformedRealm)
+	_, err = store.ListTrust("alice")
+	assert.Equal(t, err, ErrMalformedRealm)
+}
@@ -0,0 +1,177 @@
+package provider
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryTrustStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryTrustStore()
+
+	realm, _ := ParseRealm("http://*.example.com/")
+	other, _ := ParseRealm("http://example.org/")
+
+	_, err := store.GetTrust("alice", realm)
+	assert.Equal(t, err, ErrTrustNotFound)
+
+	d := &TrustDecision{
+		UserID:   "alice",
+		Realm:    realm,
+		Identity: "http://example.com/alice",
+		Attributes: map[gopenid.NamespaceURI][]string{
+			"http://example.com/ext/sreg": []string{"email"},
+		},
+		Created: now,
+		Expires: now.Add(time.Hour),
+	}
+	if !assert.Nil(t, store.PutTrust(d)) {
+		t.FailNow()
+	}
+	assert.Nil(t, store.PutTrust(&TrustDecision{UserID: "alice", Realm: other, Created: now}))
+	assert.Nil(t, store.PutTrust(&TrustDecision{UserID: "bob", Realm: other, Created: now}))
+
+	// stored decisions are not shared with callers
+	d.Attributes["http://example.com/ext/sreg"][0] = "nickname"
+	if stored, err := store.GetTrust("alice", realm); assert.Nil(t, err) {
+		assert.Equal(t, stored.Attributes["http://example.com/ext/sreg"], []string{"email"})
+		assert.False(t, stored.IsExpired(now))
+		assert.True(t, stored.IsExpired(now.Add(time.Hour)))
+	}
+
+	if decisions, err := store.ListTrust("alice"); assert.Nil(t, err) && assert.Len(t, decisions, 2) {
+		assert.Equal(t, decisions[0].Realm.String(), "http://*.example.com/")
+		assert.Equal(t, decisions[1].Realm.String(), "http://example.org/")
+	}
+
+	store.Prune(now.Add(time.Hour))
+	_, err = store.GetTrust("alice", realm)
+	assert.Equal(t, err, ErrTrustNotFound)
+
+	assert.Nil(t, store.RevokeTrust("alice", other))
+	assert.Equal(t, store.RevokeTrust("alice", other), ErrTrustNotFound)
+	if decisions, err := store.ListTrust("alice"); assert.Nil(t, err) {
+		assert.Empty(t, decisions)
+	}
+	if decisions, err := store.ListTrust("bob"); assert.Nil(t, err) {
+		assert.Len(t, decisions, 1)
+	}
+}
+
+func TestTrustDecisionCovers(t *testing.T) {
+	ext := &testExtension{alias: "ext"}
+	handlers := []ExtensionHandler{ext}
+	req := &AuthRequest{
+		Extensions: map[gopenid.NamespaceURI]map[string]string{
+			ext.GetNamespace(): map[string]string{"required": "identity"},
+		},
+	}
+
+	assert.True(t, (&TrustDecision{}).Covers(req, handlers))
+	assert.False(t, (&TrustDecision{Attributes: map[gopenid.NamespaceURI][]string{}}).Covers(req, handlers))
+	assert.True(t, (&TrustDecision{
+		Attributes: map[gopenid.NamespaceURI][]string{
+			ext.GetNamespace(): []string{"identity"},
+		},
+	}).Covers(req, handlers))
+	assert.True(t, (&TrustDecision{Attributes: map[gopenid.NamespaceURI][]string{}}).Covers(&AuthRequest{}, handlers))
+}
+
+func TestAuthorizeTrust(t *testing.T) {
+	var (
+		consulted int
+		trustFor  time.Duration
+	)
+
+	trustStore := NewMemoryTrustStore()
+	ext := &testExtension{alias: "ext"}
+	p, err := New(endpoint, newMemoryStore(),
+		WithTrustStore(trustStore),
+		WithExtensions(ext),
+		WithAuthenticator(AuthenticatorFunc(func(req *AuthRequest) (AuthResult, error) {
+			result := AcceptResult("http://example.com/alice", "")
+			result.UserID = "alice"
+			return result, nil
+		})),
+		WithConsentDecider(ConsentDeciderFunc(func(req *AuthRequest, identity string) (AuthResult, error) {
+			consulted++
+			if req.Immediate {
+				return InteractResult("http://example.com/consent"), nil
+			}
+
+			result := AcceptResult("", "")
+			result.TrustFor = trustFor
+			// no extension is consented to
+			result.Attributes = map[gopenid.NamespaceURI][]string{}
+			return result, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	r, _ := http.NewRequest("GET", endpoint, nil)
+	authorize := func(mode string, extension bool) url.Values {
+		query := url.Values{
+			"openid.ns":         []string{gopenid.NsOpenID20.String()},
+			"openid.mode":       []string{mode},
+			"openid.identity":   []string{gopenid.NsIdentifierSelect.String()},
+			"openid.claimed_id": []string{gopenid.NsIdentifierSelect.String()},
+			"openid.return_to":  []string{"http://rp.example.com/return"},
+		}
+		if extension {
+			query.Set("openid.ns.ext", ext.GetNamespace().String())
+			query.Set("openid.ext.required", "identity")
+		}
+
+		res, err := p.Authorize(r, establishCheckIDSession(t, p, query))
+		if !assert.Nil(t, err) || !assert.True(t, res.NeedsRedirect()) {
+			t.FailNow()
+		}
+
+		location, _ := url.Parse(res.GetRedirectTo())
+		return location.Query()
+	}
+
+	// unknown realm needs the consent of the user
+	assert.Equal(t, authorize("checkid_immediate", false).Get("openid.mode"), "setup_needed")
+	assert.Equal(t, consulted, 1)
+
+	// approval which is not remembered
+	assert.Equal(t, authorize("checkid_setup", false).Get("openid.mode"), "id_res")
+	assert.Equal(t, consulted, 2)
+	assert.Equal(t, authorize("checkid_immediate", false).Get("openid.mode"), "setup_needed")
+	assert.Equal(t, consulted, 3)
+
+	// approval which is remembered without consent to the extension
+	trustFor = time.Hour
+	query := authorize("checkid_setup", true)
+	assert.Equal(t, query.Get("openid.mode"), "id_res")
+	assert.Equal(t, query.Get("openid.ext.identity"), "")
+	assert.Equal(t, consulted, 4)
+
+	query = authorize("checkid_immediate", false)
+	assert.Equal(t, query.Get("openid.mode"), "id_res")
+	assert.Equal(t, query.Get("openid.claimed_id"), "http://example.com/alice")
+	assert.Equal(t, consulted, 4)
+
+	// the extension was not consented to
+	assert.Equal(t, authorize("checkid_immediate", true).Get("openid.mode"), "setup_needed")
+	assert.Equal(t, consulted, 5)
+
+	if decisions, err := trustStore.ListTrust("alice"); assert.Nil(t, err) && assert.Len(t, decisions, 1) {
+		assert.Equal(t, decisions[0].Realm.Host, "rp.example.com")
+		assert.Equal(t, decisions[0].Identity, "http://example.com/alice")
+	}
+
+	// revoked
+	realm, _ := ParseRealm("http://rp.example.com/return")
+	assert.Nil(t, trustStore.RevokeTrust("alice", realm))
+	assert.Equal(t, authorize("checkid_immediate", false).Get("openid.mode"), "setup_needed")
+	assert.Equal(t, consulted, 6)
+}
@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"errors"
+
+	"github.com/GehirnInc/GOpenID"
+)
+
+var (
+	ErrReturnToNotInRealm = errors.New("return_to does not match realm")
+)
+
+// UnsolicitedAssertion returns a redirect to returnTo with a positive assertion of claimedID,
+// sent without a preceding checkid request as allowed by section 10 of OpenID Authentication 2.0.
+//
+// realm is checked like realms of checkid requests, and defaults to returnTo if empty.
+// localID is the OP-local identifier claimedID delegates to, and defaults to claimedID if empty.
+// The assertion is signed with a private association, so the relying party verifies it
+// by check_authentication.
+func (p *Provider) UnsolicitedAssertion(returnTo, realm, claimedID, localID string) (res Response, err error) {
+	if claimedID == "" {
+		err = ErrIdentityNotSet
+		return
+	} else if localID == "" {
+		localID = claimedID
+	}
+
+	if realm == "" {
+		realm = returnTo
+	}
+	parsedRealm, err := ParseRealm(realm)
+	if err != nil {
+		return
+	} else if !parsedRealm.Validate(returnTo) {
+		err = ErrReturnToNotInRealm
+		return
+	}
+
+	if err = checkRealm(parsedRealm, p.realmPolicy); err != nil {
+		return
+	} else if err = verifyDelegation(p.identityResolver, claimedID, localID); err != nil {
+		return
+	}
+
+	ns := gopenid.NsOpenID20
+	assertion := &openIDResponse{
+		message:       gopenid.NewMessage(ns),
+		needsRedirect: true,
+		returnTo:      returnTo,
+	}
+	assertion.AddArg(gopenid.NewMessageKey(ns, "mode"), "id_res")
+	assertion.AddArg(gopenid.NewMessageKey(ns, "op_endpoint"), gopenid.MessageValue(p.endpoint))
+	assertion.AddArg(gopenid.NewMessageKey(ns, "claimed_id"), gopenid.MessageValue(claimedID))
+	assertion.AddArg(gopenid.NewMessageKey(ns, "identity"), gopenid.MessageValue(localID))
+	assertion.AddArg(gopenid.NewMessageKey(ns, "return_to"), gopenid.MessageValue(returnTo))
+	assertion.AddArg(gopenid.NewMessageKey(ns, "response_nonce"), gopenid.GenerateNonce(p.now().UTC()))
+
+	order := []string{
+		"op_endpoint",
+		"return_to",
+		"response_nonce",
+		"assoc_handle",
+		"claimed_id",
+		"identity",
+	}
+	if err = p.signer.Sign(assertion, "", order); err != nil {
+		return
+	}
+
+	res = assertion
+	return
+}
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/GehirnInc/GOpenID"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsolicitedAssertion(t *testing.T) {
+	p, err := New(endpoint, newMemoryStore())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	res, err := p.UnsolicitedAssertion(
+		"http://rp.example.com/return?partner=1",
+		"http://*.example.com/",
+		"http://alice.example.net/",
+		"http://example.com/users/alice",
+	)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.True(t, res.NeedsRedirect())
+
+	location, err := url.Parse(res.GetRedirectTo())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, location.Host, "rp.example.com")
+
+	query := location.Query()
+	assert.Equal(t, query.Get("partner"), "1")
+	assert.Equal(t, query.Get("openid.ns"), gopenid.NsOpenID20.String())
+	assert.Equal(t, query.Get("openid.mode"), "id_res")
+	assert.Equal(t, query.Get("openid.op_endpoint"), endpoint)
+	assert.Equal(t, query.Get("openid.return_to"), "http://rp.example.com/return?partner=1")
+	assert.Equal(t, query.Get("openid.claimed_id"), "http://alice.example.net/")
+	assert.Equal(t, query.Get("openid.identity"), "http://example.com/users/alice")
+	assert.NotEqual(t, query.Get("openid.response_nonce"), "")
+	assert.Equal(t, query.Get("openid.signed"), "op_endpoint,return_to,response_nonce,assoc_handle,claimed_id,identity")
+
+	// relying parties verify it by check_authentication
+	query.Del("partner")
+	query.Set("openid.mode", "check_authentication")
+	verify, err := gopenid.MessageFromQuery(query)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	session, err := p.EstablishSession("POST", verify)
+	if assert.Nil(t, err) {
+		res, err := session.GetResponse()
+		if assert.Nil(t, err) {
+			isValid, _ := res.(*openIDResponse).GetArg(gopenid.NewMessageKey(gopenid.NsOpenID20, "is_valid"))
+			assert.Equal(t, isValid.String(), "true")
+		}
+	}
+}
+
+func TestUnsolicitedAssertionErrors(t *testing.T) {
+	p, err := New(endpoint, newMemoryStore(), WithIdentityResolver(&testIdentityResolver{}))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	for _, testCase := range []struct {
+		returnTo  string
+		realm     string
+		claimedID string
+		localID   string
+		err       error
+	}{
+		{"http://rp.example.com/return", "", "", "", ErrIdentityNotSet},
+		{"ftp://rp.example.com/return", "", "http://example.com/users/alice", "", ErrMalformedRealm},
+		{"http://rp.example.com/return", "http://example.org/", "http://example.com/users/alice", "", ErrReturnToNotInRealm},
+		{"http://rp.example.co.uk/return", "http://*.co.uk/", "http://example.com/users/alice", "", ErrPublicSuffixRealm},
+		{"http://rp.example.com/return", "", "http://alice.example.net/", "http://example.com/users/alice", ErrDelegationNotVerified},
+	} {
+		_, err := p.UnsolicitedAssertion(testCase.returnTo, testCase.realm, testCase.claimedID, testCase.localID)
+		assert.Equal(t, err, testCase.err, "%+v", testCase)
+	}
+}
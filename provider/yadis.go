@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	xrdsContentType = "application/xrds+xml"
+	htmlContentType = "text/html"
+)
+
+// GetClaimedIdentifier returns the response to r requesting claimedID.
+//
+// It is the XRDS document if r prefers application/xrds+xml to HTML, and the identity page
+// otherwise. localID is the OP-local identifier claimedID delegates to, or claimedID itself.
+// If xrdsLocation is not empty, the identity page points to it by the X-XRDS-Location header
+// and the equivalent meta element.
+func (p *Provider) GetClaimedIdentifier(r *http.Request, claimedID, localID, xrdsLocation string, data interface{}) (res Response, err error) {
+	if localID == "" {
+		localID = claimedID
+	}
+
+	if prefersXRDS(r) {
+		res = p.yadisClaimedIdentifier(localID)
+	} else {
+		var page *IdentityPage
+		if page, err = newIdentityPage(p.endpoint, claimedID, localID, xrdsLocation, data); err != nil {
+			return
+		}
+		if res, err = newHTMLResponse(p.identityTemplate, page); err != nil {
+			return
+		}
+		setXRDSLocation(res, xrdsLocation)
+	}
+
+	res.Header().Set("Vary", "Accept")
+	return
+}
+
+// GetProviderIdentifier returns the response to r requesting the OP Identifier.
+//
+// It is the XRDS document if r prefers application/xrds+xml to HTML, and the OP Identifier page otherwise.
+// If xrdsLocation is not empty, the page points to it by the X-XRDS-Location header and the equivalent
+// meta element.
+func (p *Provider) GetProviderIdentifier(r *http.Request, xrdsLocation string, data interface{}) (res Response, err error) {
+	if prefersXRDS(r) {
+		res = p.yadisProviderIdentifier()
+	} else {
+		var page *ProviderPage
+		if page, err = newProviderPage(p.endpoint, xrdsLocation, data); err != nil {
+			return
+		}
+		if res, err = newHTMLResponse(p.providerTemplate, page); err != nil {
+			return
+		}
+		setXRDSLocation(res, xrdsLocation)
+	}
+
+	res.Header().Set("Vary", "Accept")
+	return
+}
+
+func setXRDSLocation(res Response, xrdsLocation string) {
+	if xrdsLocation != "" {
+		res.Header().Set("X-XRDS-Location", xrdsLocation)
+	}
+}
+
+// prefersXRDS reports whether r accepts application/xrds+xml at least as much as HTML.
+func prefersXRDS(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return true
+	}
+
+	xrds := acceptQuality(accept, xrdsContentType)
+	return xrds > 0 && xrds >= acceptQuality(accept, htmlContentType)
+}
+
+// acceptQuality returns the quality value the Accept header accept gives mediaType,
+// taken from the most specific media range matching it.
+func acceptQuality(accept, mediaType string) float64 {
+	var (
+		quality     float64
+		specificity = -1
+	)
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		rangeType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		var s int
+		switch {
+		case rangeType == mediaType:
+			s = 2
+		case rangeType == "*/*":
+			s = 0
+		case strings.HasSuffix(rangeType, "/*") && strings.HasPrefix(mediaType, rangeType[:len(rangeType)-1]):
+			s = 1
+		default:
+			continue
+		}
+		if s < specificity {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		quality, specificity = q, s
+	}
+
+	return quality
+}
@@ -0,0 +1,203 @@
+package gopenid
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parameters of Punycode defined in RFC 3492.
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+
+	acePrefix = "xn--"
+)
+
+var (
+	ErrMalformedHost = errors.New("malformed host")
+)
+
+// NormalizeHost returns host lowercased, with its internationalized labels converted
+// to the ASCII compatible encoding. IPv6 literals in brackets are lowercased only.
+//
+// Fullwidth forms of ASCII letters, digits and hyphens are folded as NFKC does, and
+// other fullwidth forms of ASCII are rejected. Labels are not mapped by Nameprep
+// otherwise, so hosts already in Unicode normalization form C are expected.
+func NormalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", ErrMalformedHost
+	}
+
+	if strings.HasPrefix(host, "[") {
+		if !strings.HasSuffix(host, "]") {
+			return "", ErrMalformedHost
+		}
+		return strings.ToLower(host), nil
+	}
+
+	if !utf8.ValidString(host) {
+		return "", ErrMalformedHost
+	}
+
+	// IDNA label separators
+	host = strings.NewReplacer("。", ".", "．", ".", "｡", ".").Replace(host)
+
+	host = strings.Map(foldWidth, host)
+	if strings.ContainsRune(host, utf8.RuneError) {
+		return "", ErrMalformedHost
+	}
+
+	labels := strings.Split(strings.ToLower(host), ".")
+	for i, label := range labels {
+		if label == "" && i != len(labels)-1 {
+			// empty labels are allowed only as the root
+			return "", ErrMalformedHost
+		}
+
+		encoded, err := toASCIILabel(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = encoded
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// foldWidth maps fullwidth forms of ASCII letters, digits and hyphens to ASCII, and other
+// fullwidth forms of ASCII to utf8.RuneError, which never appears in valid hosts.
+func foldWidth(r rune) rune {
+	if r < '\uff01' || r > '\uff5e' {
+		return r
+	}
+
+	r -= '\uff01' - '!'
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-':
+		return r
+	default:
+		return utf8.RuneError
+	}
+}
+
+func toASCIILabel(label string) (string, error) {
+	ascii := true
+	for i := 0; i < len(label); i++ {
+		if label[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return label, nil
+	}
+
+	encoded, err := encodePunycode(label)
+	if err != nil {
+		return "", err
+	}
+
+	return acePrefix + encoded, nil
+}
+
+// encodePunycode encodes s by Punycode, as defined in RFC 3492.
+func encodePunycode(s string) (string, error) {
+	input := []rune(s)
+
+	var output []byte
+	for _, r := range input {
+		if r < utf8.RuneSelf {
+			output = append(output, byte(r))
+		}
+	}
+
+	basic := len(output)
+	handled := basic
+	if basic > 0 {
+		output = append(output, '-')
+	}
+
+	var (
+		n     = rune(punycodeInitialN)
+		delta = 0
+		bias  = punycodeInitialBias
+	)
+	for handled < len(input) {
+		m := rune(utf8.MaxRune)
+		for _, r := range input {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+
+		if int(m-n) > (int(^uint32(0)>>1)-delta)/(handled+1) {
+			return "", ErrMalformedHost
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+
+		for _, r := range input {
+			if r < n {
+				delta++
+			}
+
+			if r == n {
+				q := delta
+				for k := punycodeBase; ; k += punycodeBase {
+					t := k - bias
+					if t < punycodeTMin {
+						t = punycodeTMin
+					} else if t > punycodeTMax {
+						t = punycodeTMax
+					}
+
+					if q < t {
+						break
+					}
+					output = append(output, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+					q = (q - t) / (punycodeBase - t)
+				}
+				output = append(output, punycodeDigit(q))
+
+				bias = adaptPunycodeBias(delta, handled+1, handled == basic)
+				delta = 0
+				handled++
+			}
+		}
+
+		delta++
+		n++
+	}
+
+	return string(output), nil
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func adaptPunycodeBias(delta, numPoints int, firstTime bool) int {
+	if firstTime {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := 0
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
@@ -0,0 +1,130 @@
+package gopenid
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	DefaultRegistry = NewRegistry(
+		[]AssocType{
+			AssocHmacSha1,
+			AssocHmacSha256,
+		},
+		[]SessionType{
+			SessionNoEncryption,
+			SessionDhSha1,
+			SessionDhSha256,
+		},
+	)
+)
+
+// Registry is a set of association types and session types known by name.
+//
+// Applications can register their own types, or remove built-in ones such as HMAC-SHA1.
+type Registry struct {
+	mu           sync.RWMutex
+	assocTypes   map[string]AssocType
+	sessionTypes map[string]SessionType
+}
+
+// NewRegistry returns a new Registry which knows the given types.
+func NewRegistry(assocTypes []AssocType, sessionTypes []SessionType) *Registry {
+	r := &Registry{
+		assocTypes:   make(map[string]AssocType, len(assocTypes)),
+		sessionTypes: make(map[string]SessionType, len(sessionTypes)),
+	}
+
+	for _, t := range assocTypes {
+		r.assocTypes[t.Name()] = t
+	}
+	for _, t := range sessionTypes {
+		r.sessionTypes[t.Name()] = t
+	}
+
+	return r
+}
+
+// RegisterAssocType registers t, replacing the type which has the same name.
+func (r *Registry) RegisterAssocType(t AssocType) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.assocTypes[t.Name()] = t
+}
+
+// RemoveAssocType removes the association type named name.
+func (r *Registry) RemoveAssocType(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.assocTypes, name)
+}
+
+// GetAssocType returns the association type named name.
+func (r *Registry) GetAssocType(name string) (AssocType, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.assocTypes[name]
+	if !ok {
+		return AssocType{}, ErrUnknownAssocType
+	}
+	return t, nil
+}
+
+// GetAssocTypeNames returns sorted names of registered association types.
+func (r *Registry) GetAssocTypeNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.assocTypes))
+	for name := range r.assocTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// RegisterSessionType registers t, replacing the type which has the same name.
+func (r *Registry) RegisterSessionType(t SessionType) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessionTypes[t.Name()] = t
+}
+
+// RemoveSessionType removes the session type named name.
+func (r *Registry) RemoveSessionType(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessionTypes, name)
+}
+
+// GetSessionType returns the session type named name.
+func (r *Registry) GetSessionType(name string) (SessionType, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.sessionTypes[name]
+	if !ok {
+		return SessionType{}, ErrUnknownSessionType
+	}
+	return t, nil
+}
+
+// GetSessionTypeNames returns sorted names of registered session types.
+func (r *Registry) GetSessionTypeNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.sessionTypes))
+	for name := range r.sessionTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,41 @@
+package gopenid
+
+import (
+	"crypto/sha512"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	assocHmacSha512 := NewAssocType("HMAC-SHA512", sha512.New, sha512.Size)
+	sessionDhSha512 := NewSessionType("DH-SHA512", assocHmacSha512)
+
+	r := NewRegistry(
+		[]AssocType{AssocHmacSha1, AssocHmacSha256},
+		[]SessionType{SessionNoEncryption, SessionDhSha1, SessionDhSha256},
+	)
+
+	_, err := r.GetAssocType("HMAC-SHA512")
+	assert.Equal(t, err, ErrUnknownAssocType)
+	_, err = r.GetSessionType("DH-SHA512")
+	assert.Equal(t, err, ErrUnknownSessionType)
+
+	r.RegisterAssocType(assocHmacSha512)
+	r.RegisterSessionType(sessionDhSha512)
+
+	if assocType, err := r.GetAssocType("HMAC-SHA512"); assert.Nil(t, err) {
+		assert.Equal(t, assocType.GetSecretSize(), sha512.Size)
+	}
+	if sessionType, err := r.GetSessionType("DH-SHA512"); assert.Nil(t, err) {
+		assert.True(t, sessionType.Supports(assocHmacSha512))
+		assert.False(t, sessionType.Supports(AssocHmacSha256))
+	}
+
+	r.RemoveAssocType(AssocHmacSha1.Name())
+	_, err = r.GetAssocType(AssocHmacSha1.Name())
+	assert.Equal(t, err, ErrUnknownAssocType)
+
+	assert.Equal(t, r.GetAssocTypeNames(), []string{"HMAC-SHA256", "HMAC-SHA512"})
+	assert.Equal(t, r.GetSessionTypeNames(), []string{"DH-SHA1", "DH-SHA256", "DH-SHA512", "no-encryption"})
+}
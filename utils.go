@@ -4,11 +4,16 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"io/ioutil"
 	"math/big"
 	"time"
 )
 
+var (
+	ErrMalformedNonce = errors.New("malformed nonce")
+)
+
 func generateRandomString(length int) string {
 	str := make([]rune, length)
 
@@ -37,6 +42,21 @@ func GenerateNonce(now time.Time) MessageValue {
 	return MessageValue(ts + salt)
 }
 
+// ParseNonce returns the time at which nonce was generated by GenerateNonce.
+func ParseNonce(nonce MessageValue) (time.Time, error) {
+	const layout = "2006-01-02T15:04:05Z"
+	if len(nonce) < len(layout) {
+		return time.Time{}, ErrMalformedNonce
+	}
+
+	ts, err := time.Parse(layout, nonce.String()[:len(layout)])
+	if err != nil {
+		return time.Time{}, ErrMalformedNonce
+	}
+
+	return ts, nil
+}
+
 func EncodeBase64(input []byte) (b []byte) {
 	encoded := bytes.NewBuffer(nil)
 	encoder := base64.NewEncoder(base64.StdEncoding, encoded)
@@ -3,35 +3,277 @@ package gopenid
 import (
 	"bytes"
 	"encoding/xml"
+	"errors"
+	"io"
+	"math/rand"
+	"sort"
+	"time"
 )
 
 const (
 	NsOpenID20Server NamespaceURI = "http://specs.openid.net/auth/2.0/server" // OpenID 2.0 Server.
 	NsOpenID20Signon NamespaceURI = "http://specs.openid.net/auth/2.0/signon" // OpenID 2.0 Signon.
 
-	NsXRDS  NamespaceURI = "xri://$xrds"         // Namespace for generic XRDS.
-	NsXRD20 NamespaceURI = "xri://$xrd*($v*2.0)" // Namespace for XRDS version 2.0.
+	NsXRDS     NamespaceURI = "xri://$xrds"                 // Namespace for generic XRDS.
+	NsXRD20    NamespaceURI = "xri://$xrd*($v*2.0)"         // Namespace for XRDS version 2.0.
+	NsOpenIDXR NamespaceURI = "http://openid.net/xmlns/1.0" // Namespace for OpenID 1.x elements in XRDS.
+)
+
+var (
+	ErrNoXRD = errors.New("XRDS document has no XRD element")
 )
 
 // XRDSDocument represents XRDS Document.
 type XRDSDocument struct {
-	XMLName xml.Name `xml:"xri://$xrds XRDS"`
-	XRD     XRDSXRDElement
+	XMLName xml.Name         `xml:"xri://$xrds XRDS"`
+	XRDs    []XRDSXRDElement `xml:"XRD"`
+}
+
+// GetXRD returns the XRD element describing the resource, which is the last one per Yadis.
+func (et *XRDSDocument) GetXRD() (*XRDSXRDElement, error) {
+	if len(et.XRDs) == 0 {
+		return nil, ErrNoXRD
+	}
+
+	return &et.XRDs[len(et.XRDs)-1], nil
+}
+
+// FinalXRD returns the XRD element describing the resource, or nil if et has none.
+// It stands in for the XRD field, which held the only XRD element before XRDs.
+func (et *XRDSDocument) FinalXRD() *XRDSXRDElement {
+	xrd, _ := et.GetXRD()
+	return xrd
 }
 
 // XRDSXRDElement represents XRD node tree in XRDS document.
 type XRDSXRDElement struct {
-	XMLName  xml.Name `xml:"xri://$xrd*($v*2.0) XRD"`
-	Services []XRDSServiceElement
+	XMLName     xml.Name             `xml:"xri://$xrd*($v*2.0) XRD"`
+	CanonicalID string               `xml:"CanonicalID,omitempty"`
+	Expires     string               `xml:"Expires,omitempty"`
+	Services    []XRDSServiceElement `xml:"Service"`
+	// Extra holds unknown elements as is.
+	Extra []XRDSAnyElement `xml:",any"`
+}
+
+// GetExpires returns the time the XRD expires at.
+// It returns false if the XRD has no valid Expires element.
+func (xrd *XRDSXRDElement) GetExpires() (time.Time, bool) {
+	if xrd.Expires == "" {
+		return time.Time{}, false
+	}
+
+	expires, err := time.Parse(time.RFC3339, xrd.Expires)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return expires, true
+}
+
+// GetServices returns services of the XRD ordered by priority, randomizing the order of ties.
+// Services without priority come last.
+func (xrd *XRDSXRDElement) GetServices() []XRDSServiceElement {
+	services := make([]XRDSServiceElement, len(xrd.Services))
+	copy(services, xrd.Services)
+
+	priorities := make([]*int, len(services))
+	for i := range services {
+		priorities[i] = services[i].Priority
+	}
+	sortByPriority(priorities, func(i, j int) {
+		services[i], services[j] = services[j], services[i]
+	})
+
+	return services
+}
+
+// FindServices returns services of the XRD having any of types, ordered as GetServices.
+func (xrd *XRDSXRDElement) FindServices(types ...NamespaceURI) (found []XRDSServiceElement) {
+	for _, service := range xrd.GetServices() {
+		if service.HasType(types...) {
+			found = append(found, service)
+		}
+	}
+
+	return
 }
 
 // XRDSServiceElement represents Service node in XRDS document.
 type XRDSServiceElement struct {
-	XMLName  xml.Name `xml:"xri://$xrd*($v*2.0) Service"`
-	Priority int      `xml:"priority,attr"`
-	Type     []string `xml:"Type"`
-	URI      string   `xml:"URI"`
-	LocalID  string   `xml:"LocalID,omitempty"`
+	XMLName  xml.Name         `xml:"xri://$xrd*($v*2.0) Service"`
+	Priority *int             `xml:"priority,attr,omitempty"`
+	Type     []string         `xml:"Type"`
+	URIs     []XRDSURIElement `xml:"URI"`
+	LocalID  string           `xml:"LocalID,omitempty"`
+	// Delegate is the OP-local identifier for OpenID 1.x.
+	Delegate string `xml:"http://openid.net/xmlns/1.0 Delegate,omitempty"`
+	// Extra holds unknown elements as is.
+	Extra []XRDSAnyElement `xml:",any"`
+}
+
+// HasType reports whether the service has any of types.
+func (service *XRDSServiceElement) HasType(types ...NamespaceURI) bool {
+	for _, t := range service.Type {
+		for _, expected := range types {
+			if t == expected.String() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// GetPriority returns the priority of the service.
+// It returns false if the service has no priority, in which case Priority was 0 before it became a pointer.
+func (service *XRDSServiceElement) GetPriority() (int, bool) {
+	if service.Priority == nil {
+		return 0, false
+	}
+
+	return *service.Priority, true
+}
+
+// PrimaryURI returns the URI of the service with the highest priority, preferring the first one of ties.
+// It stands in for the URI field, which held the only URI before URIs. It returns "" if the service has none.
+func (service *XRDSServiceElement) PrimaryURI() string {
+	var primary *XRDSURIElement
+	for i := range service.URIs {
+		uri := &service.URIs[i]
+		if primary == nil || uri.Priority != nil && (primary.Priority == nil || *uri.Priority < *primary.Priority) {
+			primary = uri
+		}
+	}
+
+	if primary == nil {
+		return ""
+	}
+	return primary.Value
+}
+
+// GetURIs returns URIs of the service ordered by priority, randomizing the order of ties.
+// URIs without priority come last.
+func (service *XRDSServiceElement) GetURIs() []XRDSURIElement {
+	uris := make([]XRDSURIElement, len(service.URIs))
+	copy(uris, service.URIs)
+
+	priorities := make([]*int, len(uris))
+	for i := range uris {
+		priorities[i] = uris[i].Priority
+	}
+	sortByPriority(priorities, func(i, j int) {
+		uris[i], uris[j] = uris[j], uris[i]
+	})
+
+	return uris
+}
+
+// XRDSURIElement represents URI node in XRDS document.
+type XRDSURIElement struct {
+	Priority *int   `xml:"priority,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+// XRDSAnyElement holds an element unknown to this package.
+type XRDSAnyElement struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	InnerXML string     `xml:",innerxml"`
+}
+
+// OpenIDEndpoint is an OpenID service endpoint found in XRDS document.
+type OpenIDEndpoint struct {
+	// Type is one of NsOpenID20Server, NsOpenID20Signon, NsOpenID11 and NsOpenID10.
+	Type NamespaceURI
+	// URI is the URL of the OP Endpoint.
+	URI string
+	// LocalID is the OP-local identifier, if any.
+	LocalID string
+}
+
+// IsProviderIdentifier reports whether the endpoint was found by discovery on an OP Identifier.
+func (endpoint *OpenIDEndpoint) IsProviderIdentifier() bool {
+	return endpoint.Type == NsOpenID20Server
+}
+
+// GetOpenIDEndpoints returns OpenID endpoints of et in the order relying parties should try them.
+//
+// OP Identifier elements take precedence over Claimed Identifier elements of OpenID 2.0,
+// which take precedence over those of OpenID 1.x. Endpoints of the same kind are ordered by
+// the priority of their services and URIs.
+func (et *XRDSDocument) GetOpenIDEndpoints() (endpoints []OpenIDEndpoint, err error) {
+	xrd, err := et.GetXRD()
+	if err != nil {
+		return
+	}
+
+	for _, t := range []NamespaceURI{NsOpenID20Server, NsOpenID20Signon, NsOpenID11, NsOpenID10} {
+		for _, service := range xrd.FindServices(t) {
+			localID := service.LocalID
+			switch t {
+			case NsOpenID20Server:
+				localID = ""
+			case NsOpenID11, NsOpenID10:
+				if service.Delegate != "" {
+					localID = service.Delegate
+				}
+			}
+
+			for _, uri := range service.GetURIs() {
+				endpoints = append(endpoints, OpenIDEndpoint{
+					Type:    t,
+					URI:     uri.Value,
+					LocalID: localID,
+				})
+			}
+		}
+
+		if t == NsOpenID20Server && len(endpoints) > 0 {
+			// OP Identifier elements are used exclusively
+			return
+		}
+	}
+
+	return
+}
+
+// sortByPriority sorts elements whose priorities are priorities by swap, ascending by priority.
+// Elements without priority come last, and the order of ties is randomized.
+func sortByPriority(priorities []*int, swap func(i, j int)) {
+	s := &prioritySorter{
+		priorities: priorities,
+		swap:       swap,
+	}
+
+	for i := len(priorities) - 1; i > 0; i-- {
+		s.Swap(i, rand.Intn(i+1))
+	}
+	sort.Stable(s)
+}
+
+type prioritySorter struct {
+	priorities []*int
+	swap       func(i, j int)
+}
+
+func (s *prioritySorter) Len() int {
+	return len(s.priorities)
+}
+
+func (s *prioritySorter) Less(i, j int) bool {
+	pi, pj := s.priorities[i], s.priorities[j]
+	if pi == nil {
+		return false
+	} else if pj == nil {
+		return true
+	}
+
+	return *pi < *pj
+}
+
+func (s *prioritySorter) Swap(i, j int) {
+	s.priorities[i], s.priorities[j] = s.priorities[j], s.priorities[i]
+	s.swap(i, j)
 }
 
 // EncodeXRDS returns et as XML document.
@@ -46,3 +288,18 @@ func EncodeXRDS(et *XRDSDocument) ([]byte, error) {
 
 	return b.Bytes(), nil
 }
+
+// DecodeXRDS parses XRDS document read from r.
+func DecodeXRDS(r io.Reader) (et *XRDSDocument, err error) {
+	et = new(XRDSDocument)
+	if err = xml.NewDecoder(r).Decode(et); err != nil {
+		et = nil
+		return
+	}
+
+	if len(et.XRDs) == 0 {
+		et = nil
+		err = ErrNoXRD
+	}
+	return
+}
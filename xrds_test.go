@@ -0,0 +1,172 @@
+package gopenid
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testXRDS = `<?xml version="1.0" encoding="UTF-8"?>
+<xrds:XRDS xmlns:xrds="xri://$xrds" xmlns="xri://$xrd*($v*2.0)" xmlns:openid="http://openid.net/xmlns/1.0">
+  <XRD>
+    <Service>
+      <Type>http://specs.openid.net/auth/2.0/server</Type>
+      <URI>http://redirected.example.com/</URI>
+    </Service>
+  </XRD>
+  <XRD version="2.0">
+    <CanonicalID>=!1234</CanonicalID>
+    <Expires>2030-01-02T03:04:05Z</Expires>
+    <Service>
+      <Type>http://openid.net/signon/1.1</Type>
+      <URI>http://op.example.com/v1</URI>
+      <openid:Delegate>http://example.com/alice-v1</openid:Delegate>
+    </Service>
+    <Service priority="20">
+      <Type>http://specs.openid.net/auth/2.0/signon</Type>
+      <URI priority="10">http://backup.example.com/openid</URI>
+      <URI priority="0">http://op.example.com/openid</URI>
+      <LocalID>http://example.com/alice</LocalID>
+      <custom:Extra xmlns:custom="http://example.com/custom" attr="value"><custom:Child>text</custom:Child></custom:Extra>
+    </Service>
+    <Service priority="10">
+      <Type>http://example.com/unknown</Type>
+      <URI>http://example.com/unknown</URI>
+    </Service>
+  </XRD>
+</xrds:XRDS>`
+
+func TestDecodeXRDS(t *testing.T) {
+	et, err := DecodeXRDS(strings.NewReader(testXRDS))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	xrd, err := et.GetXRD()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Len(t, et.XRDs, 2)
+	assert.Equal(t, xrd.CanonicalID, "=!1234")
+	if expires, ok := xrd.GetExpires(); assert.True(t, ok) {
+		assert.Equal(t, expires, time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC))
+	}
+
+	services := xrd.GetServices()
+	if assert.Len(t, services, 3) {
+		assert.Equal(t, services[0].Type, []string{"http://example.com/unknown"})
+		assert.Equal(t, services[1].Type, []string{NsOpenID20Signon.String()})
+		assert.Equal(t, services[2].Type, []string{NsOpenID11.String()})
+		assert.Nil(t, services[2].Priority)
+
+		uris := services[1].GetURIs()
+		if assert.Len(t, uris, 2) {
+			assert.Equal(t, uris[0].Value, "http://op.example.com/openid")
+			assert.Equal(t, uris[1].Value, "http://backup.example.com/openid")
+		}
+
+		if assert.Len(t, services[1].Extra, 1) {
+			assert.Equal(t, services[1].Extra[0].XMLName.Space, "http://example.com/custom")
+			assert.Equal(t, services[1].Extra[0].XMLName.Local, "Extra")
+		}
+	}
+
+	endpoints, err := et.GetOpenIDEndpoints()
+	if assert.Nil(t, err) {
+		assert.Equal(t, endpoints, []OpenIDEndpoint{
+			{Type: NsOpenID20Signon, URI: "http://op.example.com/openid", LocalID: "http://example.com/alice"},
+			{Type: NsOpenID20Signon, URI: "http://backup.example.com/openid", LocalID: "http://example.com/alice"},
+			{Type: NsOpenID11, URI: "http://op.example.com/v1", LocalID: "http://example.com/alice-v1"},
+		})
+	}
+
+	// OP Identifier elements are used exclusively
+	et.XRDs = et.XRDs[:1]
+	endpoints, err = et.GetOpenIDEndpoints()
+	if assert.Nil(t, err) && assert.Len(t, endpoints, 1) {
+		assert.True(t, endpoints[0].IsProviderIdentifier())
+		assert.Equal(t, endpoints[0].URI, "http://redirected.example.com/")
+	}
+
+	// unknown elements survive encoding
+	b, err := EncodeXRDS(&XRDSDocument{XRDs: []XRDSXRDElement{*xrd}})
+	if assert.Nil(t, err) {
+		decoded, err := DecodeXRDS(bytes.NewReader(b))
+		if assert.Nil(t, err) {
+			extra := decoded.XRDs[0].Services[1].Extra
+			if assert.Len(t, extra, 1) {
+				assert.Contains(t, extra[0].InnerXML, "text")
+				assert.Contains(t, extra[0].Attrs, xml.Attr{Name: xml.Name{Local: "attr"}, Value: "value"})
+			}
+		}
+	}
+
+	_, err = DecodeXRDS(strings.NewReader(`<XRDS xmlns="xri://$xrds"></XRDS>`))
+	assert.Equal(t, err, ErrNoXRD)
+	_, err = DecodeXRDS(strings.NewReader(`<html></html>`))
+	assert.NotNil(t, err)
+}
+
+func TestSortByPriority(t *testing.T) {
+	one, two := 1, 2
+	services := []XRDSServiceElement{
+		{Priority: nil, LocalID: "none"},
+		{Priority: &two, LocalID: "two"},
+		{Priority: &one, LocalID: "one-a"},
+		{Priority: &one, LocalID: "one-b"},
+	}
+	xrd := &XRDSXRDElement{Services: services}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		sorted := xrd.GetServices()
+		assert.Equal(t, sorted[2].LocalID, "two")
+		assert.Equal(t, sorted[3].LocalID, "none")
+		seen[sorted[0].LocalID] = true
+	}
+
+	// ties are randomized
+	assert.Equal(t, seen, map[string]bool{"one-a": true, "one-b": true})
+	// the original order is kept
+	assert.Equal(t, xrd.Services, services)
+	assert.Equal(t, xrd.Services[0].LocalID, "none")
+}
+
+func TestXRDSAccessors(t *testing.T) {
+	et, err := DecodeXRDS(strings.NewReader(testXRDS))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	xrd := et.FinalXRD()
+	if assert.NotNil(t, xrd) {
+		assert.Equal(t, xrd.CanonicalID, "=!1234")
+
+		if priority, ok := xrd.Services[0].GetPriority(); assert.False(t, ok) {
+			assert.Equal(t, priority, 0)
+		}
+		if priority, ok := xrd.Services[1].GetPriority(); assert.True(t, ok) {
+			assert.Equal(t, priority, 20)
+		}
+
+		assert.Equal(t, xrd.Services[0].PrimaryURI(), "http://op.example.com/v1")
+		assert.Equal(t, xrd.Services[1].PrimaryURI(), "http://op.example.com/openid")
+	}
+
+	one := 1
+	service := &XRDSServiceElement{
+		URIs: []XRDSURIElement{
+			{Value: "http://example.com/none"},
+			{Priority: &one, Value: "http://example.com/one-a"},
+			{Priority: &one, Value: "http://example.com/one-b"},
+		},
+	}
+	assert.Equal(t, service.PrimaryURI(), "http://example.com/one-a")
+	assert.Equal(t, (&XRDSServiceElement{}).PrimaryURI(), "")
+	assert.Nil(t, (&XRDSDocument{}).FinalXRD())
+}